@@ -9,6 +9,7 @@ import (
 	"github.com/wojciech-malota-wojcik/ioc"
 	"github.com/wojciech-sif/localnet"
 	"github.com/wojciech-sif/localnet/infra"
+	"github.com/wojciech-sif/localnet/infra/targets"
 	"github.com/wojciech-sif/localnet/lib/run"
 )
 
@@ -49,6 +50,50 @@ func main() {
 		}
 		rootCmd.AddCommand(destroyCmd)
 
+		appCmd := &cobra.Command{
+			Use:   "app",
+			Short: "Controls single app running in environment",
+		}
+		appCmd.AddCommand(&cobra.Command{
+			Use:   "stop <name>",
+			Short: "Stops app",
+			Args:  cobra.ExactArgs(1),
+			RunE:  cmdF.Cmd(localnet.AppStop),
+		})
+		appCmd.AddCommand(&cobra.Command{
+			Use:   "start <name>",
+			Short: "Starts app stopped previously",
+			Args:  cobra.ExactArgs(1),
+			RunE:  cmdF.Cmd(localnet.AppStart),
+		})
+		appCmd.AddCommand(&cobra.Command{
+			Use:   "restart <name>",
+			Short: "Restarts app",
+			Args:  cobra.ExactArgs(1),
+			RunE:  cmdF.Cmd(localnet.AppRestart),
+		})
+		rootCmd.AddCommand(appCmd)
+
+		attachCmd := &cobra.Command{
+			Use:   "attach [app]",
+			Short: "Attaches terminal to running environment, window of the app is selected if it is specified",
+			Args:  cobra.MaximumNArgs(1),
+			RunE:  cmdF.Cmd(localnet.Attach),
+		}
+		addFlags(attachCmd, configF)
+		addSetFlag(attachCmd, c, configF)
+		rootCmd.AddCommand(attachCmd)
+
+		doctorCmd := &cobra.Command{
+			Use:   "doctor",
+			Short: "Reports apps whose state differs from the one recorded in spec",
+			RunE:  cmdF.Cmd(localnet.Doctor),
+		}
+		addFlags(doctorCmd, configF)
+		addSetFlag(doctorCmd, c, configF)
+		doctorCmd.Flags().BoolVar(&configF.Repair, "repair", false, "Start apps which should be running and redeploy the missing ones")
+		rootCmd.AddCommand(doctorCmd)
+
 		testsCmd := &cobra.Command{
 			Use:   "tests",
 			Short: "Runs integration tests",
@@ -58,25 +103,75 @@ func main() {
 		addFilterFlag(testsCmd, configF)
 		rootCmd.AddCommand(testsCmd)
 
+		addNodeCmd := &cobra.Command{
+			Use:   "add-node <chain> <name>",
+			Short: "Adds non-validator node to the chain running in environment",
+			Args:  cobra.ExactArgs(2),
+			RunE:  cmdF.Cmd(localnet.AddNode),
+		}
+		addFlags(addNodeCmd, configF)
+		addSetFlag(addNodeCmd, c, configF)
+		addNodeCmd.Flags().BoolVar(&configF.StateSync, "state-sync", false, "Restore state of the node from snapshot instead of syncing all the blocks")
+		rootCmd.AddCommand(addNodeCmd)
+
+		graphCmd := &cobra.Command{
+			Use:   "graph",
+			Short: "Prints dependency graph of apps in the set",
+			RunE:  cmdF.Cmd(localnet.Graph),
+		}
+		addSetFlag(graphCmd, c, configF)
+		graphCmd.Flags().StringVar(&configF.GraphFormat, "format", "text", "Format of the graph: text | dot")
+		rootCmd.AddCommand(graphCmd)
+
 		specCmd := &cobra.Command{
-			Use:   "spec",
-			Short: "Prints specification of running environment",
+			Use:   "spec [selector]",
+			Short: "Prints specification of running environment, selector (e.g. apps.sifchain.endpoints.rpc) limits output to the part of spec",
+			Args:  cobra.MaximumNArgs(1),
 			RunE:  cmdF.Cmd(localnet.Spec),
 		}
 		addSetFlag(specCmd, c, configF)
+		specCmd.Flags().StringVar(&configF.SpecFormat, "format", defaultString("LOCALNET_SPEC_FORMAT", infra.ExportFormatJSON), "Output format: "+strings.Join([]string{infra.ExportFormatJSON, infra.ExportFormatYAML, infra.ExportFormatEnv, infra.ExportFormatDotEnv}, " | "))
 		rootCmd.AddCommand(specCmd)
 
+		exportCmd := &cobra.Command{
+			Use:   "export",
+			Short: "Prints definition of the set as docker-compose file or Kubernetes manifests",
+			RunE:  cmdF.Cmd(localnet.Export),
+		}
+		addFlags(exportCmd, configF)
+		addSetFlag(exportCmd, c, configF)
+		exportCmd.Flags().StringVar(&configF.ExportFormat, "format", "compose", "Output format: compose | kubernetes")
+		exportCmd.Flags().StringVar(&configF.ExportNetwork, "export-network", defaultString("LOCALNET_EXPORT_NETWORK", "10.96.86.0"), "/24 network IPs for exported apps are taken from, for kubernetes it has to be a part of service CIDR of the cluster")
+		rootCmd.AddCommand(exportCmd)
+
+		rootCmd.AddCommand(&cobra.Command{
+			Use:    "supervise <state-file> -- <command>...",
+			Short:  "Runs command and restarts it whenever it exits, used internally by direct target",
+			Hidden: true,
+			Args:   cobra.MinimumNArgs(2),
+			RunE:   cmdF.Cmd(localnet.Supervise),
+		})
+
 		return rootCmd.Execute()
 	})
 }
 
 func addFlags(cmd *cobra.Command, configF *localnet.ConfigFactory) {
 	cmd.Flags().StringVar(&configF.BinDir, "bin-dir", defaultString("LOCALNET_BIN_DIR", must.String(os.UserHomeDir())+"/go/bin"), "Path to directory where executables exist")
-	cmd.Flags().StringVar(&configF.Network, "network", defaultString("LOCALNET_NETWORK", "127.1.0.0"), "Network where IPs for applications are taken from (related to 'tmux' and 'direct' targets only)")
+	cmd.Flags().StringVar(&configF.Network, "network", defaultString("LOCALNET_NETWORK", ""), "Network (IPv4 or IPv6 CIDR) where IPs for applications are taken from, if empty /24 network not used by other environments is chosen automatically, network and IPs are kept until environment is destroyed (related to 'tmux', 'direct' and 'ssh' targets only)")
+	cmd.Flags().StringVar(&configF.Allocation, "allocation", defaultString("LOCALNET_ALLOCATION", ""), "Mode of allocating addresses to applications: "+infra.AllocationIPs+" (IP per app, default ports) | "+infra.AllocationPorts+" (single IP, block of free ports per app), if empty mode used to create environment or "+infra.AllocationIPs+" for new one is used, mode is kept until environment is destroyed (related to 'tmux' and 'direct' targets only)")
+	cmd.Flags().StringVar(&configF.PortsIP, "ports-ip", defaultString("LOCALNET_PORTS_IP", ""), "IP all the applications listen on in '"+infra.AllocationPorts+"' allocation mode, if empty IP used to create environment or 127.0.0.1 for new one is used, IP is kept until environment is destroyed (related to 'tmux' and 'direct' targets only)")
+	cmd.Flags().StringVar(&configF.SSHHost, "ssh-host", defaultString("LOCALNET_SSH_HOST", "localhost"), "Host (e.g. user@host or ssh://user@host:port) apps are deployed to over ssh (related to 'ssh' target only)")
+	cmd.Flags().StringVar(&configF.DockerNetwork, "docker-network", defaultString("LOCALNET_DOCKER_NETWORK", ""), "/24 network created for the environment, IPs for applications are taken from it, if empty /24 network starting from 10.86.0.0 not used by other environments is chosen automatically (related to 'docker' target only)")
+	cmd.Flags().StringVar(&configF.Registry, "registry", defaultString("LOCALNET_REGISTRY", ""), "Registry (e.g. localhost:5000) container images are pulled from before falling back to the original one, may be used as local cache for offline use (related to 'docker' target only)")
+	cmd.Flags().BoolVar(&configF.Supervise, "supervise", defaultBool("LOCALNET_SUPERVISE", false), "Restart apps with exponential backoff whenever they exit (related to 'direct' target only, use '--restart-policy "+targets.RestartPolicyAlways+"' for 'tmux' target)")
+	cmd.Flags().StringVar(&configF.RestartPolicy, "restart-policy", defaultString("LOCALNET_RESTART_POLICY", targets.RestartPolicyManual), "Policy of restarting apps which exited: "+targets.RestartPolicyManual+" (press Enter in the window) | "+targets.RestartPolicyAlways+" (restart with backoff by the same supervisor '--supervise' enables for 'direct' target) (related to 'tmux' target only)")
+	cmd.Flags().StringVar(&configF.TMuxLayout, "tmux-layout", defaultString("LOCALNET_TMUX_LAYOUT", targets.TMuxLayoutWindows), "Layout of tmux session: "+targets.TMuxLayoutWindows+" (window per app) | "+targets.TMuxLayoutDashboard+" (window per app and overview window presenting logs of all apps) (related to 'tmux' target only)")
+	cmd.Flags().StringSliceVar(&configF.WindowOrder, "window-order", defaultFilters("LOCALNET_WINDOW_ORDER"), "Apps whose tmux windows are placed first, in this order, windows of other apps are sorted by name (related to 'tmux' target only)")
 }
 
 func addSetFlag(cmd *cobra.Command, c *ioc.Container, configF *localnet.ConfigFactory) {
-	cmd.Flags().StringVar(&configF.SetName, "set", defaultString("LOCALNET_SET", "dev"), "Application set to deploy: "+strings.Join(c.Names((*infra.Set)(nil)), " | "))
+	cmd.Flags().StringVar(&configF.SetName, "set", defaultString("LOCALNET_SET", "dev"), "Application set to deploy: "+strings.Join(c.Names((*infra.Set)(nil)), " | ")+" or path to set file (.yaml, .yml, .json)")
 }
 
 func addFilterFlag(cmd *cobra.Command, configF *localnet.ConfigFactory) {
@@ -5,29 +5,40 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	osexec "os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/ridge/must"
+	"github.com/ridge/parallel"
+	"github.com/spf13/cobra"
 	"github.com/wojciech-malota-wojcik/ioc"
 	"github.com/wojciech-sif/localnet/exec"
 	"github.com/wojciech-sif/localnet/infra"
 	"github.com/wojciech-sif/localnet/infra/apps"
+	"github.com/wojciech-sif/localnet/infra/manifests"
+	"github.com/wojciech-sif/localnet/infra/targets"
 	"github.com/wojciech-sif/localnet/infra/testing"
+	"github.com/wojciech-sif/localnet/lib/logger"
 	"github.com/wojciech-sif/localnet/tests"
+	"go.uber.org/zap"
 )
 
 // Activate starts preconfigured bash environment
-func Activate(ctx context.Context, configF *ConfigFactory) error {
+func Activate(ctx context.Context, configF *ConfigFactory, cmd *cobra.Command) error {
+	config, err := configF.Config(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
 	tty := exec.Tty()
 	defer tty.Close()
 
-	config := configF.Config()
-
 	exe := must.String(filepath.EvalSymlinks(must.String(os.Executable())))
 	var path string
 	for _, p := range strings.Split(os.Getenv("PATH"), ":") {
@@ -53,11 +64,20 @@ func Activate(ctx context.Context, configF *ConfigFactory) error {
 		fmt.Sprintf("PS1=%s", "("+configF.EnvName+") "+regexp.MustCompile(`^\(.*?\) *`).ReplaceAllString(os.Getenv("PS1"), "")),
 		fmt.Sprintf("PATH=%s", path),
 		fmt.Sprintf("LOCALNET_ENV=%s", configF.EnvName),
-		fmt.Sprintf("LOCALNET_SET=%s", configF.SetName),
+		fmt.Sprintf("LOCALNET_SET=%s", config.SetName),
 		fmt.Sprintf("LOCALNET_HOME=%s", configF.HomeDir),
 		fmt.Sprintf("LOCALNET_TARGET=%s", configF.Target),
 		fmt.Sprintf("LOCALNET_BIN_DIR=%s", configF.BinDir),
 		fmt.Sprintf("LOCALNET_NETWORK=%s", configF.Network),
+		fmt.Sprintf("LOCALNET_ALLOCATION=%s", configF.Allocation),
+		fmt.Sprintf("LOCALNET_PORTS_IP=%s", configF.PortsIP),
+		fmt.Sprintf("LOCALNET_SSH_HOST=%s", configF.SSHHost),
+		fmt.Sprintf("LOCALNET_DOCKER_NETWORK=%s", configF.DockerNetwork),
+		fmt.Sprintf("LOCALNET_REGISTRY=%s", configF.Registry),
+		fmt.Sprintf("LOCALNET_SUPERVISE=%t", configF.Supervise),
+		fmt.Sprintf("LOCALNET_RESTART_POLICY=%s", configF.RestartPolicy),
+		fmt.Sprintf("LOCALNET_TMUX_LAYOUT=%s", configF.TMuxLayout),
+		fmt.Sprintf("LOCALNET_WINDOW_ORDER=%s", strings.Join(configF.WindowOrder, ",")),
 		fmt.Sprintf("LOCALNET_FILTERS=%s", strings.Join(configF.TestFilters, ",")),
 		fmt.Sprintf("LOCALNET_VERBOSE=%t", configF.VerboseLogging),
 	)
@@ -69,17 +89,106 @@ func Activate(ctx context.Context, configF *ConfigFactory) error {
 }
 
 // Start starts environment
-func Start(ctx context.Context, target infra.Target, set infra.Set, spec *infra.Spec) (retErr error) {
+func Start(ctx context.Context, target infra.Target, set infra.Set, spec *infra.Spec) error {
+	if err := set.Validate(); err != nil {
+		return err
+	}
+	if err := start(ctx, target, set, spec); err != nil {
+		return err
+	}
+	return attach(ctx, target, set, spec, "")
+}
+
+// Attach attaches terminal to running environment, if app is specified its output is presented
+func Attach(ctx context.Context, target infra.Target, set infra.Set, spec *infra.Spec, args Args) error {
+	if _, ok := target.(infra.AttachCapable); !ok {
+		return fmt.Errorf("terminal can't be attached to %s target", spec.Target)
+	}
+	var app string
+	if len(args) > 0 {
+		app = args[0]
+	}
+	return attach(ctx, target, set, spec, app)
+}
+
+func attach(ctx context.Context, target infra.Target, set infra.Set, spec *infra.Spec, app string) error {
+	attachable, ok := target.(infra.AttachCapable)
+	if !ok {
+		return nil
+	}
+	// Environment is unlocked, so other commands may be used while terminal is attached
+	if err := spec.Release(); err != nil {
+		return err
+	}
+	reporter, ok := target.(infra.HealthReporter)
+	if !ok {
+		return attachable.Attach(ctx, app)
+	}
+	// Health of apps is presented as long as terminal is attached
+	err := parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		spawn("attach", parallel.Exit, func(ctx context.Context) error {
+			return attachable.Attach(ctx, app)
+		})
+		spawn("health", parallel.Continue, func(ctx context.Context) error {
+			// Failure of health monitoring is not a reason to detach terminal
+			if err := infra.MonitorHealth(ctx, target, set, spec, reporter); err != nil && !errors.Is(err, ctx.Err()) {
+				logger.Get(ctx).Error("Monitoring health of apps failed", zap.Error(err))
+			}
+			return nil
+		})
+		return nil
+	})
+	// Health reported last would stay in the status line after detaching
+	if err := reporter.ClearHealth(ctx); err != nil {
+		logger.Get(ctx).Error("Clearing health of apps failed", zap.Error(err))
+	}
+	return err
+}
+
+func start(ctx context.Context, target infra.Target, set infra.Set, spec *infra.Spec) (retErr error) {
 	defer func() {
 		if err := spec.Save(); retErr == nil {
 			retErr = err
 		}
 	}()
+	drifts, err := infra.Reconcile(ctx, target, spec)
+	if err != nil {
+		return err
+	}
+	log := logger.Get(ctx)
+	for _, drift := range drifts {
+		log.Warn("State of app differs from the one recorded in spec", zap.String("app", drift.App),
+			zap.String("recorded", string(drift.Recorded)), zap.String("actual", string(drift.Actual)))
+	}
+
+	for _, app := range set {
+		if appDesc, exists := spec.Apps[app.Name()]; exists && appDesc.Stopped {
+			if err := startApp(ctx, target, spec, app.Name()); err != nil {
+				return err
+			}
+		}
+	}
 	return target.Deploy(ctx, set)
 }
 
 // Stop stops environment
-func Stop(ctx context.Context, target infra.Target, spec *infra.Spec) (retErr error) {
+func Stop(ctx context.Context, target infra.Target, set infra.Set, spec *infra.Spec) (retErr error) {
+	inSet := map[string]bool{}
+	for _, app := range set {
+		inSet[app.Name()] = true
+	}
+	names := make([]string, 0, len(spec.Apps))
+	for name := range spec.Apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !inSet[name] {
+			logger.Get(ctx).Warn("App is not part of the set, so it isn't started again together with the environment, use add-node to add it again",
+				zap.String("app", name))
+		}
+	}
+
 	defer func() {
 		if err := spec.Reset(); retErr == nil {
 			retErr = err
@@ -88,6 +197,152 @@ func Stop(ctx context.Context, target infra.Target, spec *infra.Spec) (retErr er
 	return target.Stop(ctx)
 }
 
+// AppStop stops single app running in the environment
+func AppStop(ctx context.Context, target infra.Target, spec *infra.Spec, args Args) (retErr error) {
+	defer func() {
+		if err := spec.Save(); retErr == nil {
+			retErr = err
+		}
+	}()
+	return stopApp(ctx, target, spec, args[0])
+}
+
+// AppStart starts single app stopped previously
+func AppStart(ctx context.Context, target infra.Target, spec *infra.Spec, args Args) (retErr error) {
+	defer func() {
+		if err := spec.Save(); retErr == nil {
+			retErr = err
+		}
+	}()
+	return startApp(ctx, target, spec, args[0])
+}
+
+// AppRestart restarts single app running in the environment
+func AppRestart(ctx context.Context, target infra.Target, spec *infra.Spec, args Args) (retErr error) {
+	defer func() {
+		if err := spec.Save(); retErr == nil {
+			retErr = err
+		}
+	}()
+	if err := stopApp(ctx, target, spec, args[0]); err != nil {
+		return err
+	}
+	return startApp(ctx, target, spec, args[0])
+}
+
+func stopApp(ctx context.Context, target infra.Target, spec *infra.Spec, name string) error {
+	appDesc, err := spec.App(name)
+	if err != nil {
+		return err
+	}
+	if !appDesc.Running {
+		return nil
+	}
+	if err := target.StopApp(ctx, name); err != nil {
+		return err
+	}
+	appDesc.Running = false
+	appDesc.Stopped = true
+	return nil
+}
+
+func startApp(ctx context.Context, target infra.Target, spec *infra.Spec, name string) error {
+	appDesc, err := spec.App(name)
+	if err != nil {
+		return err
+	}
+	if appDesc.Running {
+		return nil
+	}
+	if !appDesc.Stopped {
+		return fmt.Errorf("app %s hasn't been deployed yet, use start command to deploy it", name)
+	}
+	if err := target.StartApp(ctx, name); err != nil {
+		return err
+	}
+	appDesc.Running = true
+	appDesc.Stopped = false
+	return nil
+}
+
+// Doctor reports apps whose state differs from the one recorded in spec and repairs them if requested
+func Doctor(ctx context.Context, configF *ConfigFactory, target infra.Target, set infra.Set, appF *apps.Factory, spec *infra.Spec) (retErr error) {
+	drifts, err := infra.Reconcile(ctx, target, spec)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(spec.Apps))
+	for name := range spec.Apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if runtime := spec.Apps[name].Runtime; runtime.Restarts > 0 {
+			fmt.Printf("%s: restarted %d times, last exit code %d\n", name, runtime.Restarts, runtime.ExitCode)
+		}
+	}
+	if len(drifts) == 0 {
+		fmt.Println("No drift detected")
+		return nil
+	}
+	for _, drift := range drifts {
+		fmt.Println(drift)
+	}
+	if !configF.Repair {
+		return nil
+	}
+
+	defer func() {
+		if err := spec.Save(); retErr == nil {
+			retErr = err
+		}
+	}()
+
+	inSet := map[string]bool{}
+	for _, app := range set {
+		inSet[app.Name()] = true
+	}
+
+	// Only apps which were expected to run are repaired, apps stopped on purpose stay stopped
+	var missing, missingAdded infra.Set
+	for _, drift := range drifts {
+		if drift.Recorded != infra.AppStatusRunning {
+			continue
+		}
+		switch drift.Actual {
+		case infra.AppStatusStopped:
+			if err := startApp(ctx, target, spec, drift.App); err != nil {
+				return err
+			}
+		case infra.AppStatusMissing:
+			if !inSet[drift.App] {
+				// Apps added by add-node are rebuilt from spec
+				app, err := appF.FromSpec(drift.App)
+				if err != nil {
+					return err
+				}
+				missingAdded = append(missingAdded, app)
+				continue
+			}
+			for _, app := range set {
+				if app.Name() == drift.App {
+					missing = append(missing, app)
+				}
+			}
+		}
+	}
+	// Apps added by add-node depend on apps from the set, so they are deployed once the set is repaired
+	for _, missingSet := range []infra.Set{missing, missingAdded} {
+		if len(missingSet) == 0 {
+			continue
+		}
+		if err := target.Deploy(ctx, missingSet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Destroy destroys environment
 func Destroy(ctx context.Context, config infra.Config, target infra.Target) (retErr error) {
 	if err := target.Destroy(ctx); err != nil {
@@ -123,13 +378,96 @@ func Tests(c *ioc.Container, configF *ConfigFactory) error {
 		}()
 
 		env, tests := tests.Tests(appF)
+		if err := env.Validate(); err != nil {
+			return err
+		}
 		return testing.Run(ctx, target, env, tests, config.TestFilters)
 	}, &err)
 	return err
 }
 
-// Spec print specification of running environment
-func Spec(spec *infra.Spec, _ infra.Set) error {
-	fmt.Println(spec)
+// AddNode deploys full node joining chain running in the environment
+func AddNode(ctx context.Context, configF *ConfigFactory, target infra.Target, appF *apps.Factory, spec *infra.Spec, args Args) (retErr error) {
+	chainName, nodeName := args[0], args[1]
+	if _, exists := spec.Apps[nodeName]; exists {
+		return fmt.Errorf("app %s already exists in environment", nodeName)
+	}
+	chain, err := apps.RunningSifchain(spec, chainName)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := spec.Save(); retErr == nil {
+			retErr = err
+		}
+	}()
+	return target.Deploy(ctx, infra.Set{appF.SifchainFullNode(nodeName, chain, configF.StateSync)})
+}
+
+// Graph prints dependency graph of apps in the set
+func Graph(config infra.Config, configF *ConfigFactory, set infra.Set) error {
+	graph := set.Graph()
+	switch configF.GraphFormat {
+	case "text":
+		fmt.Print(graph)
+	case "dot":
+		fmt.Print(graph.DOT(config.SetName))
+	default:
+		return fmt.Errorf("unknown format %q", configF.GraphFormat)
+	}
+	return set.Validate()
+}
+
+// Supervise runs app and restarts it whenever it exits, it is used internally by direct target
+func Supervise(ctx context.Context, args Args) error {
+	return targets.Supervise(ctx, args[0], args[1:])
+}
+
+// Export prints definition of the set as docker-compose file or Kubernetes manifests
+func Export(c *ioc.Container, configF *ConfigFactory) error {
+	configF.DryRun = true
+	var err error
+	c.Call(func(ctx context.Context, config infra.Config, spec *infra.Spec, set infra.Set) error {
+		defer os.RemoveAll(config.HomeDir + "/dry-run")
+
+		if err := set.Validate(); err != nil {
+			return err
+		}
+		network := net.ParseIP(configF.ExportNetwork)
+		if network == nil || network.To4() == nil {
+			return fmt.Errorf("invalid export network %q", configF.ExportNetwork)
+		}
+
+		var out []byte
+		var err error
+		switch configF.ExportFormat {
+		case "compose":
+			out, err = manifests.Compose(ctx, config, spec, set, network.To4())
+		case "kubernetes":
+			out, err = manifests.Kubernetes(ctx, config, spec, set, network.To4())
+		default:
+			return fmt.Errorf("unknown format %q", configF.ExportFormat)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(out))
+		return nil
+	}, &err)
+	return err
+}
+
+// Spec prints specification of running environment, or its part pointed by selector, in requested format
+func Spec(configF *ConfigFactory, spec *infra.Spec, _ infra.Set, args Args) error {
+	var selector string
+	if len(args) > 0 {
+		selector = args[0]
+	}
+	out, err := spec.Export(selector, configF.SpecFormat)
+	if err != nil {
+		return err
+	}
+	fmt.Print(out)
 	return nil
 }
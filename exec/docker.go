@@ -19,3 +19,8 @@ func init() {
 func Docker(args ...string) *exec.Cmd {
 	return exec.Command(docker, args...)
 }
+
+// DockerIsPodman returns true if podman is used in place of docker
+func DockerIsPodman() bool {
+	return docker == "podman"
+}
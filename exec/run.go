@@ -77,8 +77,20 @@ func Kill(ctx context.Context, pids []int) error {
 						return err
 					}
 					spawn("waiter", parallel.Exit, func(ctx context.Context) error {
-						_, _ = proc.Wait()
-						return nil
+						if _, err := proc.Wait(); err == nil {
+							return nil
+						}
+						// Process is not a child of this one, so it can't be waited for
+						for {
+							if err := proc.Signal(syscall.Signal(0)); err != nil {
+								return nil
+							}
+							select {
+							case <-ctx.Done():
+								return ctx.Err()
+							case <-time.After(100 * time.Millisecond):
+							}
+						}
 					})
 					spawn("killer", parallel.Continue, func(ctx context.Context) error {
 						if err := proc.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
@@ -89,7 +101,7 @@ func Kill(ctx context.Context, pids []int) error {
 							return err
 						case <-time.After(20 * time.Second):
 						}
-						if err := proc.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+						if err := proc.Signal(syscall.SIGKILL); err != nil && !errors.Is(err, os.ErrProcessDone) {
 							return err
 						}
 						return nil
@@ -0,0 +1,11 @@
+package exec
+
+import (
+	"os/exec"
+)
+
+// SSH runs command on remote host over ssh.
+// Batch mode is used, so authentication must not require password to be typed.
+func SSH(host string, args ...string) *exec.Cmd {
+	return exec.Command("ssh", append([]string{"-o", "BatchMode=yes", "-T", "--", host}, args...)...)
+}
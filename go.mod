@@ -17,4 +17,5 @@ require (
 	github.com/wojciech-malota-wojcik/build v0.0.0-20210131144749-3ef5b00b908f
 	github.com/wojciech-malota-wojcik/ioc v1.3.1-0.20210829092813-3edb43f522c7
 	go.uber.org/zap v1.19.0
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
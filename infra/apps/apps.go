@@ -1,6 +1,9 @@
 package apps
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/wojciech-sif/localnet/infra"
 	"github.com/wojciech-sif/localnet/infra/apps/hermes"
 	"github.com/wojciech-sif/localnet/infra/apps/sifchain"
@@ -22,11 +25,59 @@ type Factory struct {
 
 // Sifchain creates new sifchain
 func (f *Factory) Sifchain(name string) *Sifchain {
-	return NewSifchain(f.config.WrapperDir, sifchain.NewExecutor(name, f.config.BinDir+"/sifnoded", f.config.AppDir+"/"+name,
-		"master"), f.spec)
+	executor := sifchain.NewExecutor(name, name, f.config.BinDir+"/sifnoded", f.config.AppDir+"/"+name, "master")
+	return NewSifchain(f.config.WrapperDir, sifchain.NewNetwork(executor), executor, f.spec)
+}
+
+// SifchainNetwork creates sifchain network with n validators, each of them being a separate app named <name>-<index>.
+// Name is used as chain ID.
+func (f *Factory) SifchainNetwork(name string, n int) []*Sifchain {
+	if n < 1 {
+		panic(fmt.Sprintf("network %s requires at least one validator", name))
+	}
+	executors := make([]*sifchain.Executor, 0, n)
+	for i := 0; i < n; i++ {
+		nodeName := fmt.Sprintf("%s-%d", name, i)
+		executors = append(executors, sifchain.NewExecutor(nodeName, name, f.config.BinDir+"/sifnoded", f.config.AppDir+"/"+nodeName, "master"))
+	}
+	network := sifchain.NewNetwork(executors...)
+	nodes := make([]*Sifchain, 0, n)
+	for _, executor := range executors {
+		peers := append([]*Sifchain{}, nodes...)
+		nodes = append(nodes, NewSifchain(f.config.WrapperDir, network, executor, f.spec, peers...))
+	}
+	return nodes
+}
+
+// SifchainFullNode creates new non-validator node joining the chain
+func (f *Factory) SifchainFullNode(name string, chain ChainPeer, stateSync bool) *SifchainFullNode {
+	executor := sifchain.NewExecutor(name, chain.ID(), f.config.BinDir+"/sifnoded", f.config.AppDir+"/"+name, "master")
+	return NewSifchainFullNode(f.config.WrapperDir, executor, f.spec, chain, stateSync)
 }
 
 // Hermes creates new hermes
 func (f *Factory) Hermes(name string, chainA, chainB hermes.Peer) *Hermes {
-	return NewHermes(f.config, "hermes", f.spec, chainA, chainB)
+	return NewHermes(f.config, name, f.spec, chainA, chainB)
+}
+
+// FromSpec rebuilds app which is not part of the set, like full node added by add-node, using its description recorded in spec
+func (f *Factory) FromSpec(name string) (infra.App, error) {
+	appDesc, err := f.spec.App(name)
+	if err != nil {
+		return nil, err
+	}
+	switch appDesc.Type {
+	case "sifchain-fullnode":
+		chain, err := RunningSifchain(f.spec, appDesc.Params["chain"])
+		if err != nil {
+			return nil, err
+		}
+		stateSync, err := strconv.ParseBool(appDesc.Params["stateSync"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid stateSync param of app %s: %w", name, err)
+		}
+		return f.SifchainFullNode(name, chain, stateSync), nil
+	default:
+		return nil, fmt.Errorf("app %s of type %s is not part of the set, it can't be redeployed", name, appDesc.Type)
+	}
 }
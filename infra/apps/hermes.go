@@ -2,7 +2,6 @@ package apps
 
 import (
 	"context"
-	"fmt"
 	osexec "os/exec"
 	"time"
 
@@ -27,11 +26,13 @@ func NewHermes(config infra.Config, name string, spec *infra.Spec, chainA, chain
 
 // Hermes represents hermes relayer
 type Hermes struct {
-	config  infra.Config
-	appDesc *infra.AppDescription
-	name    string
-	chainA  hermes.Peer
-	chainB  hermes.Peer
+	config    infra.Config
+	appDesc   *infra.AppDescription
+	name      string
+	chainA    hermes.Peer
+	chainB    hermes.Peer
+	args      []string
+	resources infra.Resources
 }
 
 // Name returns name of app
@@ -39,7 +40,25 @@ func (h *Hermes) Name() string {
 	return h.name
 }
 
-// Deploy deploys sifchain app to the target
+// AddArgs adds args passed to hermes on start
+func (h *Hermes) AddArgs(args ...string) {
+	h.args = append(h.args, args...)
+}
+
+// SetResources sets limits of resources available to the app
+func (h *Hermes) SetResources(resources infra.Resources) {
+	h.resources = resources
+}
+
+// Dependencies returns chains hermes connects
+func (h *Hermes) Dependencies() []infra.HealthCheckCapable {
+	return []infra.HealthCheckCapable{
+		h.chainA,
+		h.chainB,
+	}
+}
+
+// Deploy deploys hermes app to the target
 func (h *Hermes) Deploy(ctx context.Context, target infra.AppTarget) error {
 	bin := h.config.BinDir + "/hermes"
 	hermesHome := h.config.AppDir + "/" + h.name
@@ -52,11 +71,15 @@ func (h *Hermes) Deploy(ctx context.Context, target infra.AppTarget) error {
 		Path:       bin,
 		RequiresIP: true,
 		AppBase: infra.AppBase{
-			Name: h.name,
-			Args: []string{
+			Name:      h.name,
+			Resources: h.resources,
+			Ports: map[string]int{
+				"telemetry": 3001,
+			},
+			Args: append([]string{
 				"--config", configFile,
 				"start",
-			},
+			}, h.args...),
 			Files: []infra.File{
 				{
 					Path:        configFile,
@@ -69,22 +92,19 @@ func (h *Hermes) Deploy(ctx context.Context, target infra.AppTarget) error {
 				hermesHome,
 			},
 			Requires: infra.Prerequisites{
-				Timeout: 10 * time.Second,
-				Dependencies: []infra.HealthCheckCapable{
-					h.chainA,
-					h.chainB,
-				},
+				Timeout:      10 * time.Second,
+				Dependencies: h.Dependencies(),
 			},
 			PreFunc: func(ctx context.Context) error {
 				return exec.Run(ctx,
-					hermes("keys", "add", h.chainA.ID(), "--file", h.config.AppDir+"/"+h.chainA.ID()+"/master.json"),
-					hermes("keys", "add", h.chainB.ID(), "--file", h.config.AppDir+"/"+h.chainB.ID()+"/master.json"),
+					hermes("keys", "add", h.chainA.ID(), "--file", h.chainA.KeyFile()),
+					hermes("keys", "add", h.chainB.ID(), "--file", h.chainB.KeyFile()),
 					hermes("create", "channel", h.chainA.ID(), h.chainB.ID(), "--port-a", "transfer", "--port-b", "transfer"),
 				)
 			},
 			PostFunc: func(ctx context.Context, deployment infra.Deployment) error {
 				h.appDesc.IP = deployment.IP
-				h.appDesc.AddEndpoint("telemetry", fmt.Sprintf("%s:3001", deployment.IP))
+				addEndpoints(h.appDesc, deployment)
 				return nil
 			},
 		},
@@ -101,13 +121,13 @@ clear_packets_interval = 100
 [telemetry]
 enabled = true
 host = '{{ .IP }}'
-port = 3001
+port = {{ .Ports.telemetry }}
 
 [[chains]]
 id = '` + h.chainA.ID() + `'
-rpc_addr = 'http://` + h.chainA.IP().String() + `:26657'
-grpc_addr = 'http://` + h.chainA.IP().String() + `:9090'
-websocket_addr = 'ws://` + h.chainA.IP().String() + `:26657/websocket'
+rpc_addr = 'http://` + h.chainA.Endpoint("rpc") + `'
+grpc_addr = 'http://` + h.chainA.Endpoint("grpc") + `'
+websocket_addr = 'ws://` + h.chainA.Endpoint("rpc") + `/websocket'
 rpc_timeout = '10s'
 account_prefix = 'sif'
 key_name = '` + h.config.EnvName + "-" + h.chainA.ID() + `'
@@ -123,9 +143,9 @@ trust_threshold = { numerator = '1', denominator = '3' }
 
 [[chains]]
 id = '` + h.chainB.ID() + `'
-rpc_addr = 'http://` + h.chainB.IP().String() + `:26657'
-grpc_addr = 'http://` + h.chainB.IP().String() + `:9090'
-websocket_addr = 'ws://` + h.chainB.IP().String() + `:26657/websocket'
+rpc_addr = 'http://` + h.chainB.Endpoint("rpc") + `'
+grpc_addr = 'http://` + h.chainB.Endpoint("grpc") + `'
+websocket_addr = 'ws://` + h.chainB.Endpoint("rpc") + `/websocket'
 rpc_timeout = '10s'
 account_prefix = 'sif'
 key_name = '` + h.config.EnvName + "-" + h.chainB.ID() + `'
@@ -1,8 +1,6 @@
 package hermes
 
 import (
-	"net"
-
 	"github.com/wojciech-sif/localnet/infra"
 )
 
@@ -13,6 +11,9 @@ type Peer interface {
 	// ID returns chain id
 	ID() string
 
-	// IP returns ip used for connection
-	IP() net.IP
+	// Endpoint returns address of endpoint used for connection
+	Endpoint(name string) string
+
+	// KeyFile returns path to file containing key used by relayer to sign transactions
+	KeyFile() string
 }
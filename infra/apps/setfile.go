@@ -0,0 +1,284 @@
+package apps
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/wojciech-sif/localnet/infra"
+	"github.com/wojciech-sif/localnet/infra/apps/sifchain"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// AppTypeSifchain is the type of sifchain app used in set files
+	AppTypeSifchain = "sifchain"
+
+	// AppTypeSifchainFullNode is the type of sifchain non-validator node used in set files
+	AppTypeSifchainFullNode = "sifchain-fullnode"
+
+	// AppTypeHermes is the type of hermes app used in set files
+	AppTypeHermes = "hermes"
+)
+
+// SetFile is the definition of set stored in file
+type SetFile struct {
+	// Apps is the list of apps in the set
+	Apps []SetFileApp `json:"apps" yaml:"apps"`
+}
+
+// SetFileApp is the definition of app stored in set file
+type SetFileApp struct {
+	// Name is the name of app
+	Name string `json:"name" yaml:"name"`
+
+	// Type is the type of app: sifchain | sifchain-fullnode | hermes
+	Type string `json:"type" yaml:"type"`
+
+	// Args are additional args passed to the app's binary
+	Args []string `json:"args,omitempty" yaml:"args,omitempty"`
+
+	// Resources are limits of resources available to the app, applied by tmux and direct targets
+	Resources SetFileResources `json:"resources,omitempty" yaml:"resources,omitempty"`
+
+	// Validators is the number of validators in the network, each of them is deployed as app named <name>-<index> - used only by sifchain.
+	// 0 and 1 mean single validator deployed as app named <name>.
+	Validators int `json:"validators,omitempty" yaml:"validators,omitempty"`
+
+	// Wallets are wallets added to genesis block - used only by sifchain
+	Wallets []SetFileWallet `json:"wallets,omitempty" yaml:"wallets,omitempty"`
+
+	// Chain is the name of chain joined by node - used only by sifchain-fullnode
+	Chain string `json:"chain,omitempty" yaml:"chain,omitempty"`
+
+	// StateSync tells node to restore state from snapshot instead of syncing all the blocks - used only by sifchain-fullnode
+	StateSync bool `json:"stateSync,omitempty" yaml:"stateSync,omitempty"`
+
+	// ChainA is the name of first chain connected by relayer - used only by hermes
+	ChainA string `json:"chainA,omitempty" yaml:"chainA,omitempty"`
+
+	// ChainB is the name of second chain connected by relayer - used only by hermes
+	ChainB string `json:"chainB,omitempty" yaml:"chainB,omitempty"`
+}
+
+// SetFileResources is the definition of resource limits stored in set file
+type SetFileResources struct {
+	// CPUs is the number of CPUs app may use, e.g. 0.5
+	CPUs float64 `json:"cpus,omitempty" yaml:"cpus,omitempty"`
+
+	// Memory is the amount of memory app may use, e.g. 512M or 2G
+	Memory string `json:"memory,omitempty" yaml:"memory,omitempty"`
+}
+
+// SetFileWallet is the definition of genesis wallet stored in set file
+type SetFileWallet struct {
+	// Name is the name of the key stored in keystore
+	Name string `json:"name" yaml:"name"`
+
+	// Balances are the balances of wallet in form used by sifnoded, e.g. 100rowan
+	Balances []string `json:"balances" yaml:"balances"`
+}
+
+// IsSetFile returns true if set name refers to file
+func IsSetFile(setName string) bool {
+	switch strings.ToLower(filepath.Ext(setName)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	default:
+		return false
+	}
+}
+
+// LoadSetFile loads set definition from YAML or JSON file
+func LoadSetFile(path string) (SetFile, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return SetFile{}, err
+	}
+
+	var setFile SetFile
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&setFile)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(raw))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&setFile)
+	}
+	if err != nil {
+		return SetFile{}, fmt.Errorf("parsing set file %s failed: %w", path, err)
+	}
+	return setFile, nil
+}
+
+// SetFromFile creates set from definition stored in file
+func (f *Factory) SetFromFile(path string) (infra.Set, error) {
+	setFile, err := LoadSetFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.SetFromDefinition(setFile)
+}
+
+// SetFromDefinition creates set from definition
+func (f *Factory) SetFromDefinition(setFile SetFile) (infra.Set, error) {
+	// Chains are created first so relayers may reference them no matter where they are defined
+	chains := map[string][]*Sifchain{}
+	for _, appDef := range setFile.Apps {
+		if appDef.Name == "" {
+			return nil, fmt.Errorf("app of type %q has no name", appDef.Type)
+		}
+		if _, exists := chains[appDef.Name]; exists {
+			return nil, fmt.Errorf("app %s is defined more than once", appDef.Name)
+		}
+		if appDef.Type != AppTypeSifchain {
+			continue
+		}
+		if appDef.Validators < 0 {
+			return nil, fmt.Errorf("app %s has invalid number of validators: %d", appDef.Name, appDef.Validators)
+		}
+
+		resources, err := parseResources(appDef)
+		if err != nil {
+			return nil, err
+		}
+
+		var nodes []*Sifchain
+		if appDef.Validators > 1 {
+			nodes = f.SifchainNetwork(appDef.Name, appDef.Validators)
+		} else {
+			nodes = []*Sifchain{f.Sifchain(appDef.Name)}
+		}
+		for _, node := range nodes {
+			node.AddArgs(appDef.Args...)
+			node.SetResources(resources)
+		}
+		wallets := map[string]bool{}
+		for _, walletDef := range appDef.Wallets {
+			if wallets[walletDef.Name] {
+				return nil, fmt.Errorf("app %s: wallet %s declared twice", appDef.Name, walletDef.Name)
+			}
+			wallets[walletDef.Name] = true
+
+			balances := make([]sifchain.Balance, 0, len(walletDef.Balances))
+			for _, balanceStr := range walletDef.Balances {
+				balance, err := sifchain.ParseBalance(balanceStr)
+				if err != nil {
+					return nil, fmt.Errorf("app %s, wallet %s: %w", appDef.Name, walletDef.Name, err)
+				}
+				balances = append(balances, balance)
+			}
+			nodes[0].Genesis().DeclareWallet(walletDef.Name, balances...)
+		}
+		chains[appDef.Name] = nodes
+	}
+
+	names := map[string]bool{}
+	set := make(infra.Set, 0, len(setFile.Apps))
+	for _, appDef := range setFile.Apps {
+		switch appDef.Type {
+		case AppTypeSifchain:
+			for _, node := range chains[appDef.Name] {
+				if names[node.Name()] {
+					return nil, fmt.Errorf("app %s is defined more than once", node.Name())
+				}
+				names[node.Name()] = true
+				set = append(set, node)
+			}
+		case AppTypeSifchainFullNode:
+			if names[appDef.Name] {
+				return nil, fmt.Errorf("app %s is defined more than once", appDef.Name)
+			}
+			names[appDef.Name] = true
+
+			chain, err := peer(chains, appDef.Name, appDef.Chain)
+			if err != nil {
+				return nil, err
+			}
+			resources, err := parseResources(appDef)
+			if err != nil {
+				return nil, err
+			}
+			node := f.SifchainFullNode(appDef.Name, chain, appDef.StateSync)
+			node.AddArgs(appDef.Args...)
+			node.SetResources(resources)
+			set = append(set, node)
+		case AppTypeHermes:
+			if names[appDef.Name] {
+				return nil, fmt.Errorf("app %s is defined more than once", appDef.Name)
+			}
+			names[appDef.Name] = true
+
+			chainA, err := peer(chains, appDef.Name, appDef.ChainA)
+			if err != nil {
+				return nil, err
+			}
+			chainB, err := peer(chains, appDef.Name, appDef.ChainB)
+			if err != nil {
+				return nil, err
+			}
+			resources, err := parseResources(appDef)
+			if err != nil {
+				return nil, err
+			}
+			relayer := f.Hermes(appDef.Name, chainA, chainB)
+			relayer.AddArgs(appDef.Args...)
+			relayer.SetResources(resources)
+			set = append(set, relayer)
+		default:
+			return nil, fmt.Errorf("app %s has unknown type %q", appDef.Name, appDef.Type)
+		}
+	}
+	return set, nil
+}
+
+// minCPUs is the lowest CPU limit accepted, cgroup rejects CPU time quota below 1ms per 100ms period
+const minCPUs = 0.01
+
+var memoryUnits = map[string]uint64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+}
+
+func parseResources(appDef SetFileApp) (infra.Resources, error) {
+	if appDef.Resources.CPUs < 0 {
+		return infra.Resources{}, fmt.Errorf("app %s has invalid number of CPUs: %v", appDef.Name, appDef.Resources.CPUs)
+	}
+	if appDef.Resources.CPUs > 0 && appDef.Resources.CPUs < minCPUs {
+		return infra.Resources{}, fmt.Errorf("app %s has too low number of CPUs: %v, at least %v is required", appDef.Name, appDef.Resources.CPUs, minCPUs)
+	}
+	resources := infra.Resources{CPUs: appDef.Resources.CPUs}
+	if appDef.Resources.Memory == "" {
+		return resources, nil
+	}
+
+	// Units are binary ones, so M, MB and MiB mean the same
+	memory := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(appDef.Resources.Memory)), "B"), "I")
+	number := strings.TrimRight(memory, "KMG")
+	multiplier, ok := memoryUnits[memory[len(number):]]
+	value, err := strconv.ParseUint(number, 10, 64)
+	if !ok || err != nil || value == 0 {
+		return infra.Resources{}, fmt.Errorf("app %s has invalid memory limit %q, use e.g. 512M or 2G", appDef.Name, appDef.Resources.Memory)
+	}
+	resources.Memory = value * multiplier
+	return resources, nil
+}
+
+func peer(chains map[string][]*Sifchain, appName, chainName string) (*Sifchain, error) {
+	if chainName == "" {
+		return nil, fmt.Errorf("app %s: chain to connect is not specified", appName)
+	}
+	nodes, exists := chains[chainName]
+	if !exists {
+		return nil, fmt.Errorf("app %s: chain %s is not defined in the set", appName, chainName)
+	}
+	// Apps connect to the first validator of the network
+	return nodes[0], nil
+}
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -18,22 +19,32 @@ import (
 	"github.com/wojciech-sif/localnet/lib/retry"
 )
 
-// NewSifchain creates new sifchain app
-func NewSifchain(wrapperDir string, executor *sifchain.Executor, spec *infra.Spec) *Sifchain {
+// snapshotInterval is the number of blocks between state sync snapshots taken by validators
+const snapshotInterval = 100
+
+// NewSifchain creates new sifchain app.
+// Peers are other validators of the same network, they are deployed before this one and node keeps persistent connection with them.
+func NewSifchain(wrapperDir string, network *sifchain.Network, executor *sifchain.Executor, spec *infra.Spec, peers ...*Sifchain) *Sifchain {
+	appDesc := spec.DescribeApp("sifchain", executor.Name())
+	appDesc.AddParam("chainID", executor.ChainID())
 	return &Sifchain{
 		wrapperDir: wrapperDir,
+		network:    network,
 		executor:   executor,
-		genesis:    sifchain.NewGenesis(executor),
-		appDesc:    spec.DescribeApp("sifchain", executor.Name()),
+		appDesc:    appDesc,
+		peers:      peers,
 	}
 }
 
 // Sifchain represents sifchain
 type Sifchain struct {
 	wrapperDir string
+	network    *sifchain.Network
 	executor   *sifchain.Executor
-	genesis    *sifchain.Genesis
 	appDesc    *infra.AppDescription
+	peers      []*Sifchain
+	args       []string
+	resources  infra.Resources
 
 	// mu is here to protect appDesc.IP
 	mu sync.RWMutex
@@ -41,7 +52,7 @@ type Sifchain struct {
 
 // ID returns chain ID
 func (s *Sifchain) ID() string {
-	return s.executor.Name()
+	return s.executor.ChainID()
 }
 
 // Name returns name of app
@@ -57,25 +68,58 @@ func (s *Sifchain) IP() net.IP {
 	return s.appDesc.IP
 }
 
+// Endpoint returns address of endpoint exposed by chain, empty string is returned if chain hasn't been deployed yet
+func (s *Sifchain) Endpoint(name string) string {
+	return s.appDesc.Endpoint(name)
+}
+
+// KeyFile returns path to file containing key of the node's operator
+func (s *Sifchain) KeyFile() string {
+	return s.executor.KeyFile()
+}
+
 // Genesis returns configurator of genesis block
 func (s *Sifchain) Genesis() *sifchain.Genesis {
-	return s.genesis
+	return s.network.Genesis()
+}
+
+// Dependencies returns validators which have to be deployed before this one
+func (s *Sifchain) Dependencies() []infra.HealthCheckCapable {
+	deps := make([]infra.HealthCheckCapable, 0, len(s.peers))
+	for _, peer := range s.peers {
+		deps = append(deps, peer)
+	}
+	return deps
+}
+
+// AddArgs adds args passed to sifnoded on start
+func (s *Sifchain) AddArgs(args ...string) {
+	s.args = append(s.args, args...)
+}
+
+// SetResources sets limits of resources available to the app
+func (s *Sifchain) SetResources(resources infra.Resources) {
+	s.resources = resources
 }
 
 // Client creates new client for sifchain blockchain
 func (s *Sifchain) Client() *sifchain.Client {
-	return sifchain.NewClient(s.executor, s.IP())
+	return s.network.Client(s.Endpoint("rpc"))
 }
 
 // HealthCheck checks if sifchain is empty
 func (s *Sifchain) HealthCheck(ctx context.Context) error {
-	if s.IP() == nil {
+	return healthCheck(ctx, s.Endpoint("rpc"))
+}
+
+func healthCheck(ctx context.Context, rpcAddr string) error {
+	if rpcAddr == "" {
 		return retry.Retryable(fmt.Errorf("sifchain hasn't started yet"))
 	}
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	req := must.HTTPRequest(http.NewRequestWithContext(ctx, http.MethodGet, "http://"+s.IP().String()+":26657/status", nil))
+	req := must.HTTPRequest(http.NewRequestWithContext(ctx, http.MethodGet, "http://"+rpcAddr+"/status", nil))
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -117,51 +161,79 @@ func (s *Sifchain) Deploy(ctx context.Context, target infra.AppTarget) error {
 		Path:       s.executor.Bin(),
 		RequiresIP: true,
 		AppBase: infra.AppBase{
-			Name: s.executor.Name(),
-			Args: []string{
+			Name:      s.executor.Name(),
+			Resources: s.resources,
+			Ports:     sifchainPorts(),
+			Args: append([]string{
 				"start",
 				"--home", s.executor.Home(),
-				"--rpc.laddr", "tcp://{{ .IP }}:26657",
-				"--p2p.laddr", "tcp://{{ .IP }}:26656",
-				"--grpc.address", "{{ .IP }}:9090",
-				"--rpc.pprof_laddr", "{{ .IP }}:6060",
-			},
+				"--rpc.laddr", "tcp://{{ .Host }}:{{ .Ports.rpc }}",
+				"--p2p.laddr", "tcp://{{ .Host }}:{{ .Ports.p2p }}",
+				"--grpc.address", "{{ .Host }}:{{ .Ports.grpc }}",
+				"--rpc.pprof_laddr", "{{ .Host }}:{{ .Ports.pprof }}",
+				// Snapshots are taken so full nodes may join the chain using state sync
+				"--state-sync.snapshot-interval", strconv.Itoa(snapshotInterval),
+				"--state-sync.snapshot-keep-recent", "2",
+			}, s.args...),
 			Copy: []string{
 				s.executor.Bin(),
 				s.executor.Home(),
 			},
 			PreFunc: func(ctx context.Context) error {
-				return s.executor.PrepareNode(ctx, s.genesis)
+				if err := s.network.Prepare(ctx); err != nil {
+					return err
+				}
+				peers := make([]string, 0, len(s.peers))
+				for _, peer := range s.peers {
+					nodeID, err := peer.executor.NodeID(ctx)
+					if err != nil {
+						return err
+					}
+					peers = append(peers, nodeID+"@"+peer.Endpoint("p2p"))
+				}
+				return s.executor.SetPersistentPeers(peers)
 			},
 			PostFunc: func(ctx context.Context, deployment infra.Deployment) error {
 				s.mu.Lock()
 				defer s.mu.Unlock()
 
 				s.appDesc.IP = deployment.IP
-				s.appDesc.AddEndpoint("rpc", fmt.Sprintf("%s:26657", deployment.IP))
-				s.appDesc.AddEndpoint("p2p", fmt.Sprintf("%s:26656", deployment.IP))
-				s.appDesc.AddEndpoint("grpc", fmt.Sprintf("%s:9090", deployment.IP))
-				s.appDesc.AddEndpoint("pprof", fmt.Sprintf("%s:6060", deployment.IP))
+				addEndpoints(s.appDesc, deployment)
 
-				return s.saveClientWrapper(s.wrapperDir)
+				return saveClientWrapper(s.wrapperDir, s.executor, s.appDesc.Endpoint("rpc"))
 			},
 		},
 	})
 }
 
-func (s *Sifchain) saveClientWrapper(wrapperDir string) error {
-	// Call to this function is already protected by mutex so referencing s.appDesc.IP here is safe
+// sifchainPorts returns default ports sifnoded listens on
+func sifchainPorts() map[string]int {
+	return map[string]int{
+		"rpc":   26657,
+		"p2p":   26656,
+		"grpc":  9090,
+		"pprof": 6060,
+	}
+}
+
+// addEndpoints records endpoint for each port app listens on
+func addEndpoints(appDesc *infra.AppDescription, deployment infra.Deployment) {
+	for name, port := range deployment.Ports {
+		appDesc.AddEndpoint(name, infra.JoinHostPort(deployment.IP, port))
+	}
+}
 
+func saveClientWrapper(wrapperDir string, executor *sifchain.Executor, rpcAddr string) error {
 	client := `#!/bin/sh
 OPTS=""
 if [ "$1" == "tx" ] || [ "$1" == "q" ]; then
-	OPTS="$OPTS --chain-id ""` + s.executor.Name() + `"" --node ""tcp://` + s.appDesc.IP.String() + `:26657"""
+	OPTS="$OPTS --chain-id ""` + executor.ChainID() + `"" --node ""tcp://` + rpcAddr + `"""
 fi
 if [ "$1" == "tx" ] || [ "$1" == "keys" ]; then
 	OPTS="$OPTS --keyring-backend ""test"""
 fi
 
-exec ` + s.executor.Bin() + ` --home "` + s.executor.Home() + `" "$@" $OPTS
+exec ` + executor.Bin() + ` --home "` + executor.Home() + `" "$@" $OPTS
 `
-	return ioutil.WriteFile(wrapperDir+"/"+s.executor.Name(), []byte(client), 0o700)
+	return ioutil.WriteFile(wrapperDir+"/"+executor.Name(), []byte(client), 0o700)
 }
@@ -5,17 +5,18 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-	"net"
 	osexec "os/exec"
+	"regexp"
 	"strings"
 
 	"github.com/wojciech-sif/localnet/exec"
 )
 
 // NewExecutor returns new executor
-func NewExecutor(name, binPath, homeDir, keyName string) *Executor {
+func NewExecutor(name, chainID, binPath, homeDir, keyName string) *Executor {
 	return &Executor{
 		name:    name,
+		chainID: chainID,
 		binPath: binPath,
 		homeDir: homeDir,
 		keyName: keyName,
@@ -25,16 +26,22 @@ func NewExecutor(name, binPath, homeDir, keyName string) *Executor {
 // Executor exposes methods for executing sifnoded binary
 type Executor struct {
 	name    string
+	chainID string
 	binPath string
 	homeDir string
 	keyName string
 }
 
-// Name returns name of the chain
+// Name returns name of the node
 func (e *Executor) Name() string {
 	return e.name
 }
 
+// ChainID returns ID of the chain
+func (e *Executor) ChainID() string {
+	return e.chainID
+}
+
 // Bin returns path to sifnode binary
 func (e *Executor) Bin() string {
 	return e.binPath
@@ -45,6 +52,11 @@ func (e *Executor) Home() string {
 	return e.homeDir
 }
 
+// KeyFile returns path to file containing key of the node's operator
+func (e *Executor) KeyFile() string {
+	return e.homeDir + "/" + e.keyName + ".json"
+}
+
 // AddKey adds key to the client
 func (e *Executor) AddKey(ctx context.Context, name string) (addr, validatorAddr string, err error) {
 	keyData := &bytes.Buffer{}
@@ -61,51 +73,90 @@ func (e *Executor) AddKey(ctx context.Context, name string) (addr, validatorAddr
 	return strings.TrimSuffix(addrBuf.String(), "\n"), strings.TrimSuffix(validatorAddrBuf.String(), "\n"), ioutil.WriteFile(e.homeDir+"/"+name+".json", keyData.Bytes(), 0o600)
 }
 
-// PrepareNode prepares node to start
+// PrepareNode prepares single-validator node to start
 func (e *Executor) PrepareNode(ctx context.Context, genesis *Genesis) error {
-	addr, valAddr, err := e.AddKey(ctx, e.keyName)
-	if err != nil {
-		return err
-	}
+	return PrepareNetwork(ctx, genesis, e)
+}
+
+// InitNode initializes home directory of the node which does not produce its own genesis
+func (e *Executor) InitNode(ctx context.Context) error {
+	return exec.Run(ctx, e.sifnoded("init", e.name, "--chain-id", e.chainID, "-o"))
+}
 
-	cmds := []*osexec.Cmd{
-		e.sifnoded("init", e.name, "--chain-id", e.name, "-o"),
-		e.sifnoded("add-genesis-account", addr, "500000000000000000000000rowan,990000000000000000000000000stake", "--keyring-backend", "test"),
-		e.sifnoded("add-genesis-validators", valAddr, "--keyring-backend", "test"),
+// SetGenesis replaces genesis document of the node
+func (e *Executor) SetGenesis(genesis []byte) error {
+	return ioutil.WriteFile(e.homeDir+"/config/genesis.json", genesis, 0o600)
+}
+
+// NodeID returns ID of the node used in p2p communication
+func (e *Executor) NodeID(ctx context.Context) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := exec.Run(ctx, e.sifnodedOut(buf, "tendermint", "show-node-id")); err != nil {
+		return "", err
 	}
-	for wallet, balances := range genesis.wallets {
-		if len(balances) == 0 {
-			continue
-		}
-		balancesStr := ""
-		for _, balance := range balances {
-			if balancesStr != "" {
-				balancesStr += ","
-			}
-			balancesStr += balance.Amount.String() + balance.Denom
-		}
-		cmds = append(cmds, e.sifnoded("add-genesis-account", wallet.Address, balancesStr, "--keyring-backend", "test"))
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+var (
+	persistentPeersRegExp  = regexp.MustCompile(`(?m)^persistent_peers = .*$`)
+	addrBookStrictRegExp   = regexp.MustCompile(`(?m)^addr_book_strict = .*$`)
+	allowDuplicateIPRegExp = regexp.MustCompile(`(?m)^allow_duplicate_ip = .*$`)
+	stateSyncRegExp        = regexp.MustCompile(`(?ms)^\[statesync\]$.*?(^\[|\z)`)
+	enableRegExp           = regexp.MustCompile(`(?m)^enable = .*$`)
+	rpcServersRegExp       = regexp.MustCompile(`(?m)^rpc_servers = .*$`)
+	trustHeightRegExp      = regexp.MustCompile(`(?m)^trust_height = .*$`)
+	trustHashRegExp        = regexp.MustCompile(`(?m)^trust_hash = .*$`)
+)
+
+// SetPersistentPeers sets peers node keeps connection with, peers are in the form of <node id>@<ip>:<port>
+func (e *Executor) SetPersistentPeers(peers []string) error {
+	return e.updateConfig(func(config []byte) []byte {
+		return persistentPeersRegExp.ReplaceAll(config, []byte(fmt.Sprintf("persistent_peers = %q", strings.Join(peers, ","))))
+	})
+}
+
+// EnableStateSync configures node to sync state from snapshots served by rpcServers, verified against trusted block
+func (e *Executor) EnableStateSync(rpcServers []string, trustHeight int64, trustHash string) error {
+	return e.updateConfig(func(config []byte) []byte {
+		return stateSyncRegExp.ReplaceAllFunc(config, func(section []byte) []byte {
+			section = enableRegExp.ReplaceAll(section, []byte("enable = true"))
+			section = rpcServersRegExp.ReplaceAll(section, []byte(fmt.Sprintf("rpc_servers = %q", strings.Join(rpcServers, ","))))
+			section = trustHeightRegExp.ReplaceAll(section, []byte(fmt.Sprintf("trust_height = %d", trustHeight)))
+			return trustHashRegExp.ReplaceAll(section, []byte(fmt.Sprintf("trust_hash = %q", trustHash)))
+		})
+	})
+}
+
+// AllowLocalPeers configures node to accept peers running on private and duplicated IPs
+func (e *Executor) AllowLocalPeers() error {
+	return e.updateConfig(func(config []byte) []byte {
+		config = addrBookStrictRegExp.ReplaceAll(config, []byte("addr_book_strict = false"))
+		return allowDuplicateIPRegExp.ReplaceAll(config, []byte("allow_duplicate_ip = true"))
+	})
+}
+
+func (e *Executor) updateConfig(updateFn func(config []byte) []byte) error {
+	configFile := e.homeDir + "/config/config.toml"
+	config, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
 	}
-	cmds = append(cmds,
-		e.sifnoded("gentx", e.keyName, "1000000000000000000000000stake", "--chain-id", e.name, "--keyring-backend", "test"),
-		e.sifnoded("collect-gentxs"),
-	)
-	return exec.Run(ctx, cmds...)
+	return ioutil.WriteFile(configFile, updateFn(config), 0o600)
 }
 
 // QBankBalances queries for bank balances owned by address
-func (e *Executor) QBankBalances(ctx context.Context, address string, ip net.IP) ([]byte, error) {
+func (e *Executor) QBankBalances(ctx context.Context, address, rpcAddr string) ([]byte, error) {
 	balances := &bytes.Buffer{}
-	if err := exec.Run(ctx, e.sifnodedOut(balances, "q", "bank", "balances", address, "--chain-id", e.name, "--node", fmt.Sprintf("tcp://%s:26657", ip), "--output", "json")); err != nil {
+	if err := exec.Run(ctx, e.sifnodedOut(balances, "q", "bank", "balances", address, "--chain-id", e.chainID, "--node", "tcp://"+rpcAddr, "--output", "json")); err != nil {
 		return nil, err
 	}
 	return balances.Bytes(), nil
 }
 
 // TxBankSend sends tokens from one address to another
-func (e *Executor) TxBankSend(ctx context.Context, sender, address string, balance Balance, ip net.IP) ([]byte, error) {
+func (e *Executor) TxBankSend(ctx context.Context, sender, address string, balance Balance, rpcAddr string) ([]byte, error) {
 	tx := &bytes.Buffer{}
-	if err := exec.Run(ctx, e.sifnodedOut(tx, "tx", "bank", "send", sender, address, balance.Amount.String()+balance.Denom, "--yes", "--chain-id", e.name, "--node", fmt.Sprintf("tcp://%s:26657", ip), "--keyring-backend", "test", "--output", "json")); err != nil {
+	if err := exec.Run(ctx, e.sifnodedOut(tx, "tx", "bank", "send", sender, address, balance.Amount.String()+balance.Denom, "--yes", "--chain-id", e.chainID, "--node", "tcp://"+rpcAddr, "--keyring-backend", "test", "--output", "json")); err != nil {
 		return nil, err
 	}
 	return tx.Bytes(), nil
@@ -0,0 +1,164 @@
+package sifchain
+
+import (
+	"context"
+	"io/ioutil"
+	osexec "os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/wojciech-sif/localnet/exec"
+)
+
+// NewNetwork returns new network of validators sharing the same genesis block
+func NewNetwork(validators ...*Executor) *Network {
+	if len(validators) == 0 {
+		panic("network requires at least one validator")
+	}
+	return &Network{
+		validators: validators,
+		genesis:    NewGenesis(validators[0]),
+	}
+}
+
+// Network represents validators running the same chain
+type Network struct {
+	validators []*Executor
+	genesis    *Genesis
+
+	mu       sync.Mutex
+	prepared bool
+}
+
+// ChainID returns ID of the chain
+func (n *Network) ChainID() string {
+	return n.validators[0].ChainID()
+}
+
+// Genesis returns configurator of genesis block shared by all the validators
+func (n *Network) Genesis() *Genesis {
+	return n.genesis
+}
+
+// Client creates new client connected to the node exposing rpc endpoint at rpcAddr
+func (n *Network) Client(rpcAddr string) *Client {
+	// Keys of wallets added to genesis are stored by the first validator
+	return NewClient(n.validators[0], rpcAddr)
+}
+
+// Prepare prepares all the validators to start, it is done once no matter how many times function is called
+func (n *Network) Prepare(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.prepared {
+		return nil
+	}
+	if err := PrepareNetwork(ctx, n.genesis, n.validators...); err != nil {
+		return err
+	}
+	n.prepared = true
+	return nil
+}
+
+// PrepareNetwork initializes validators, generates genesis block containing all of them and distributes it.
+// Genesis is built in home directory of the first validator.
+func PrepareNetwork(ctx context.Context, genesis *Genesis, validators ...*Executor) error {
+	if err := genesis.createDeclaredWallets(ctx); err != nil {
+		return err
+	}
+
+	main := validators[0]
+	var cmds []*osexec.Cmd
+	for _, v := range validators {
+		addr, valAddr, err := v.AddKey(ctx, v.keyName)
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds,
+			v.sifnoded("init", v.name, "--chain-id", v.chainID, "-o"),
+			main.sifnoded("add-genesis-account", addr, "500000000000000000000000rowan,990000000000000000000000000stake", "--keyring-backend", "test"),
+			main.sifnoded("add-genesis-validators", valAddr, "--keyring-backend", "test"),
+		)
+	}
+	for wallet, balances := range genesis.wallets {
+		if len(balances) == 0 {
+			continue
+		}
+		balancesStr := ""
+		for _, balance := range balances {
+			if balancesStr != "" {
+				balancesStr += ","
+			}
+			balancesStr += balance.Amount.String() + balance.Denom
+		}
+		cmds = append(cmds, main.sifnoded("add-genesis-account", wallet.Address, balancesStr, "--keyring-backend", "test"))
+	}
+	if err := exec.Run(ctx, cmds...); err != nil {
+		return err
+	}
+
+	// Each validator has to see its own account in genesis to generate gentx
+	if err := distributeGenesis(main, validators[1:]); err != nil {
+		return err
+	}
+	for _, v := range validators {
+		if err := exec.Run(ctx, v.sifnoded("gentx", v.keyName, "1000000000000000000000000stake", "--chain-id", v.chainID, "--keyring-backend", "test")); err != nil {
+			return err
+		}
+	}
+	for _, v := range validators[1:] {
+		if err := copyDir(v.homeDir+"/config/gentx", main.homeDir+"/config/gentx"); err != nil {
+			return err
+		}
+	}
+	if err := exec.Run(ctx, main.sifnoded("collect-gentxs")); err != nil {
+		return err
+	}
+	if err := distributeGenesis(main, validators[1:]); err != nil {
+		return err
+	}
+
+	if len(validators) > 1 {
+		// All the validators run on the same host so they use private and, depending on target, the same IPs
+		for _, v := range validators {
+			if err := v.AllowLocalPeers(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func distributeGenesis(main *Executor, validators []*Executor) error {
+	genesis, err := ioutil.ReadFile(main.homeDir + "/config/genesis.json")
+	if err != nil {
+		return err
+	}
+	for _, v := range validators {
+		if err := ioutil.WriteFile(v.homeDir+"/config/genesis.json", genesis, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyDir(src, dst string) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(src, f.Name()))
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dst, f.Name()), content, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package sifchain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ridge/must"
+)
+
+// Status is the status of node returned by its RPC endpoint
+type Status struct {
+	// NodeID is the ID of the node used in p2p communication
+	NodeID string
+
+	// ChainID is the ID of the chain
+	ChainID string
+
+	// LatestBlockHeight is the height of the latest block known by node
+	LatestBlockHeight int64
+
+	// LatestBlockHash is the hash of the latest block known by node
+	LatestBlockHash string
+}
+
+// FetchStatus fetches status of the node listening on rpcAddr
+func FetchStatus(ctx context.Context, rpcAddr string) (Status, error) {
+	data := struct {
+		Result struct {
+			NodeInfo struct {
+				ID      string `json:"id"`
+				Network string `json:"network"`
+			} `json:"node_info"` // nolint: tagliatelle
+			SyncInfo struct {
+				LatestBlockHash   string `json:"latest_block_hash"`   // nolint: tagliatelle
+				LatestBlockHeight string `json:"latest_block_height"` // nolint: tagliatelle
+			} `json:"sync_info"` // nolint: tagliatelle
+		} `json:"result"`
+	}{}
+	if err := rpcCall(ctx, rpcAddr, "/status", &data); err != nil {
+		return Status{}, err
+	}
+
+	height, err := strconv.ParseInt(data.Result.SyncInfo.LatestBlockHeight, 10, 64)
+	if err != nil {
+		return Status{}, fmt.Errorf("invalid block height %q: %w", data.Result.SyncInfo.LatestBlockHeight, err)
+	}
+	return Status{
+		NodeID:            data.Result.NodeInfo.ID,
+		ChainID:           data.Result.NodeInfo.Network,
+		LatestBlockHeight: height,
+		LatestBlockHash:   data.Result.SyncInfo.LatestBlockHash,
+	}, nil
+}
+
+// FetchGenesis fetches genesis document from the node listening on rpcAddr
+func FetchGenesis(ctx context.Context, rpcAddr string) ([]byte, error) {
+	data := struct {
+		Result struct {
+			Genesis json.RawMessage `json:"genesis"`
+		} `json:"result"`
+	}{}
+	if err := rpcCall(ctx, rpcAddr, "/genesis", &data); err != nil {
+		return nil, err
+	}
+	return data.Result.Genesis, nil
+}
+
+// FetchBlockHash fetches hash of the block at height from the node listening on rpcAddr
+func FetchBlockHash(ctx context.Context, rpcAddr string, height int64) (string, error) {
+	data := struct {
+		Result struct {
+			BlockID struct {
+				Hash string `json:"hash"`
+			} `json:"block_id"` // nolint: tagliatelle
+		} `json:"result"`
+	}{}
+	if err := rpcCall(ctx, rpcAddr, fmt.Sprintf("/block?height=%d", height), &data); err != nil {
+		return "", err
+	}
+	return data.Result.BlockID.Hash, nil
+}
+
+func rpcCall(ctx context.Context, rpcAddr, path string, result interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req := must.HTTPRequest(http.NewRequestWithContext(ctx, http.MethodGet, "http://"+rpcAddr+path, nil))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc call %s failed, status code: %d, response: %s", path, resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, result)
+}
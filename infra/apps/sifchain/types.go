@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/big"
-	"net"
+	"regexp"
 	"sync"
 
 	"github.com/wojciech-sif/localnet/lib/rnd"
@@ -29,11 +29,27 @@ type Balance struct {
 	Denom string `json:"denom"`
 }
 
+var balanceRegExp = regexp.MustCompile(`^([0-9]+)([a-zA-Z][a-zA-Z0-9/]*)$`)
+
+// ParseBalance parses balance in the form used by sifnoded, e.g. 100rowan
+func ParseBalance(balance string) (Balance, error) {
+	matches := balanceRegExp.FindStringSubmatch(balance)
+	if matches == nil {
+		return Balance{}, fmt.Errorf("invalid balance: %s", balance)
+	}
+	amount, ok := big.NewInt(0).SetString(matches[1], 10)
+	if !ok {
+		return Balance{}, fmt.Errorf("invalid amount in balance: %s", balance)
+	}
+	return Balance{Amount: amount, Denom: matches[2]}, nil
+}
+
 // NewGenesis returns new genesis configurator
 func NewGenesis(executor *Executor) *Genesis {
 	return &Genesis{
 		executor: executor,
 		wallets:  map[Wallet][]Balance{},
+		declared: map[string][]Balance{},
 	}
 }
 
@@ -41,13 +57,18 @@ func NewGenesis(executor *Executor) *Genesis {
 type Genesis struct {
 	executor *Executor
 
-	mu      sync.Mutex
-	wallets map[Wallet][]Balance
+	mu       sync.Mutex
+	wallets  map[Wallet][]Balance
+	declared map[string][]Balance
 }
 
 // AddWallet adds wallet with balances to the genesis
 func (g *Genesis) AddWallet(ctx context.Context, balances ...Balance) (Wallet, error) {
-	name := rnd.GetRandomName()
+	return g.AddNamedWallet(ctx, rnd.GetRandomName(), balances...)
+}
+
+// AddNamedWallet adds wallet with balances and predefined key name to the genesis
+func (g *Genesis) AddNamedWallet(ctx context.Context, name string, balances ...Balance) (Wallet, error) {
 	addr, _, err := g.executor.AddKey(ctx, name)
 	if err != nil {
 		return Wallet{}, err
@@ -62,24 +83,49 @@ func (g *Genesis) AddWallet(ctx context.Context, balances ...Balance) (Wallet, e
 	return wallet, nil
 }
 
+// DeclareWallet declares wallet which is created when node is prepared, right before genesis block is generated
+func (g *Genesis) DeclareWallet(name string, balances ...Balance) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, exists := g.declared[name]; exists {
+		panic(fmt.Sprintf("wallet %s has been already declared", name))
+	}
+	g.declared[name] = balances
+}
+
+func (g *Genesis) createDeclaredWallets(ctx context.Context) error {
+	g.mu.Lock()
+	declared := g.declared
+	g.declared = map[string][]Balance{}
+	g.mu.Unlock()
+
+	for name, balances := range declared {
+		if _, err := g.AddNamedWallet(ctx, name, balances...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // NewClient creates new client for sifchain
-func NewClient(executor *Executor, ip net.IP) *Client {
+func NewClient(executor *Executor, rpcAddr string) *Client {
 	return &Client{
 		executor: executor,
-		ip:       ip,
+		rpcAddr:  rpcAddr,
 	}
 }
 
 // Client is the client for sifchain blockchain
 type Client struct {
 	executor *Executor
-	ip       net.IP
+	rpcAddr  string
 }
 
 // QBankBalances queries for bank balances owned by wallet
 func (c *Client) QBankBalances(ctx context.Context, wallet Wallet) (map[string]Balance, error) {
 	// FIXME (wojciech): support pagination
-	out, err := c.executor.QBankBalances(ctx, wallet.Address, c.ip)
+	out, err := c.executor.QBankBalances(ctx, wallet.Address, c.rpcAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -106,7 +152,7 @@ func (c *Client) QBankBalances(ctx context.Context, wallet Wallet) (map[string]B
 
 // TxBankSend sends tokens from one wallet to another
 func (c *Client) TxBankSend(ctx context.Context, sender, receiver Wallet, balance Balance) (string, error) {
-	out, err := c.executor.TxBankSend(ctx, sender.Name, receiver.Address, balance, c.ip)
+	out, err := c.executor.TxBankSend(ctx, sender.Name, receiver.Address, balance, c.rpcAddr)
 	if err != nil {
 		return "", err
 	}
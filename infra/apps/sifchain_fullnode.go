@@ -0,0 +1,236 @@
+package apps
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wojciech-sif/localnet/infra"
+	"github.com/wojciech-sif/localnet/infra/apps/sifchain"
+	"github.com/wojciech-sif/localnet/lib/logger"
+	"github.com/wojciech-sif/localnet/lib/retry"
+	"go.uber.org/zap"
+)
+
+// ChainPeer is the chain full node joins
+type ChainPeer interface {
+	infra.HealthCheckCapable
+
+	// ID returns chain id
+	ID() string
+}
+
+// NewSifchainFullNode creates new non-validator node joining the chain.
+// Node syncs blocks from the chain or restores state from snapshot if stateSync is true.
+func NewSifchainFullNode(wrapperDir string, executor *sifchain.Executor, spec *infra.Spec, chain ChainPeer, stateSync bool) *SifchainFullNode {
+	appDesc := spec.DescribeApp("sifchain-fullnode", executor.Name())
+	appDesc.AddParam("chain", chain.Name())
+	appDesc.AddParam("chainID", executor.ChainID())
+	appDesc.AddParam("stateSync", strconv.FormatBool(stateSync))
+	return &SifchainFullNode{
+		wrapperDir: wrapperDir,
+		executor:   executor,
+		spec:       spec,
+		appDesc:    appDesc,
+		chain:      chain,
+		stateSync:  stateSync,
+	}
+}
+
+// SifchainFullNode represents sifchain node which is not a validator
+type SifchainFullNode struct {
+	wrapperDir string
+	executor   *sifchain.Executor
+	spec       *infra.Spec
+	appDesc    *infra.AppDescription
+	chain      ChainPeer
+	stateSync  bool
+	args       []string
+	resources  infra.Resources
+
+	// mu is here to protect appDesc.IP
+	mu sync.RWMutex
+}
+
+// ID returns chain ID
+func (n *SifchainFullNode) ID() string {
+	return n.executor.ChainID()
+}
+
+// Name returns name of app
+func (n *SifchainFullNode) Name() string {
+	return n.executor.Name()
+}
+
+// IP returns IP node listens on
+func (n *SifchainFullNode) IP() net.IP {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.appDesc.IP
+}
+
+// AddArgs adds args passed to sifnoded on start
+func (n *SifchainFullNode) AddArgs(args ...string) {
+	n.args = append(n.args, args...)
+}
+
+// SetResources sets limits of resources available to the app
+func (n *SifchainFullNode) SetResources(resources infra.Resources) {
+	n.resources = resources
+}
+
+// Dependencies returns chain which has to be deployed before the node
+func (n *SifchainFullNode) Dependencies() []infra.HealthCheckCapable {
+	return []infra.HealthCheckCapable{n.chain}
+}
+
+// HealthCheck checks if node is synced to the point where it knows any block
+func (n *SifchainFullNode) HealthCheck(ctx context.Context) error {
+	return healthCheck(ctx, n.appDesc.Endpoint("rpc"))
+}
+
+// Deploy deploys full node to the target
+func (n *SifchainFullNode) Deploy(ctx context.Context, target infra.AppTarget) error {
+	return target.DeployBinary(ctx, infra.Binary{
+		Path:       n.executor.Bin(),
+		RequiresIP: true,
+		AppBase: infra.AppBase{
+			Name:      n.executor.Name(),
+			Resources: n.resources,
+			Ports:     sifchainPorts(),
+			Args: append([]string{
+				"start",
+				"--home", n.executor.Home(),
+				"--rpc.laddr", "tcp://{{ .Host }}:{{ .Ports.rpc }}",
+				"--p2p.laddr", "tcp://{{ .Host }}:{{ .Ports.p2p }}",
+				"--grpc.address", "{{ .Host }}:{{ .Ports.grpc }}",
+				"--rpc.pprof_laddr", "{{ .Host }}:{{ .Ports.pprof }}",
+			}, n.args...),
+			Copy: []string{
+				n.executor.Bin(),
+				n.executor.Home(),
+			},
+			Requires: infra.Prerequisites{
+				Timeout:      20 * time.Second,
+				Dependencies: n.Dependencies(),
+			},
+			PreFunc: n.prepare,
+			PostFunc: func(ctx context.Context, deployment infra.Deployment) error {
+				n.mu.Lock()
+				defer n.mu.Unlock()
+
+				n.appDesc.IP = deployment.IP
+				addEndpoints(n.appDesc, deployment)
+
+				return saveClientWrapper(n.wrapperDir, n.executor, n.appDesc.Endpoint("rpc"))
+			},
+		},
+	})
+}
+
+// prepare fetches genesis from the chain and configures node to connect to it
+func (n *SifchainFullNode) prepare(ctx context.Context) error {
+	chainDesc, err := n.spec.App(n.chain.Name())
+	if err != nil {
+		return err
+	}
+	rpcAddr := chainDesc.Endpoint("rpc")
+	p2pAddr := chainDesc.Endpoint("p2p")
+	if rpcAddr == "" || p2pAddr == "" {
+		return fmt.Errorf("chain %s does not expose rpc and p2p endpoints", n.chain.Name())
+	}
+
+	status, err := sifchain.FetchStatus(ctx, rpcAddr)
+	if err != nil {
+		return err
+	}
+	if status.ChainID != n.executor.ChainID() {
+		return fmt.Errorf("chain ID mismatch, expected: %s, got: %s", n.executor.ChainID(), status.ChainID)
+	}
+	genesis, err := sifchain.FetchGenesis(ctx, rpcAddr)
+	if err != nil {
+		return err
+	}
+
+	if err := n.executor.InitNode(ctx); err != nil {
+		return err
+	}
+	if err := n.executor.SetGenesis(genesis); err != nil {
+		return err
+	}
+	if err := n.executor.SetPersistentPeers([]string{status.NodeID + "@" + p2pAddr}); err != nil {
+		return err
+	}
+	if err := n.executor.AllowLocalPeers(); err != nil {
+		return err
+	}
+	if !n.stateSync {
+		return nil
+	}
+
+	// Node deployed together with the chain has to wait until the first snapshot is taken.
+	// Snapshot is taken once block is committed, so node waits for the next one to be sure it exists.
+	if status.LatestBlockHeight <= snapshotInterval {
+		logger.Get(ctx).Info("Waiting for the first snapshot of the chain", zap.String("chain", n.chain.Name()),
+			zap.Int64("height", status.LatestBlockHeight), zap.Int64("snapshotHeight", snapshotInterval))
+		err := retry.Do(ctx, 5*time.Second, func() error {
+			status, err = sifchain.FetchStatus(ctx, rpcAddr)
+			if err != nil {
+				return retry.Retryable(err)
+			}
+			if status.LatestBlockHeight <= snapshotInterval {
+				return retry.Retryable(fmt.Errorf("chain %s hasn't produced any snapshot yet", n.chain.Name()))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Trusted block is the one at which latest snapshot should have been taken
+	trustHeight := status.LatestBlockHeight - status.LatestBlockHeight%snapshotInterval
+	trustHash, err := sifchain.FetchBlockHash(ctx, rpcAddr, trustHeight)
+	if err != nil {
+		return err
+	}
+	// Tendermint requires at least two RPC servers to verify light blocks, it is fine to use the same one twice
+	return n.executor.EnableStateSync([]string{rpcAddr, rpcAddr}, trustHeight, trustHash)
+}
+
+// RunningSifchain returns chain deployed previously to the environment
+func RunningSifchain(spec *infra.Spec, name string) (ChainPeer, error) {
+	appDesc, exists := spec.Apps[name]
+	if !exists {
+		return nil, fmt.Errorf("app %s does not exist in environment", name)
+	}
+	if appDesc.Type != "sifchain" {
+		return nil, fmt.Errorf("app %s is of type %s, sifchain is required", name, appDesc.Type)
+	}
+	if !appDesc.Running {
+		return nil, fmt.Errorf("chain %s is not running", name)
+	}
+	return runningSifchain{name: name, chainID: appDesc.Params["chainID"], rpcAddr: appDesc.Endpoints["rpc"]}, nil
+}
+
+type runningSifchain struct {
+	name    string
+	chainID string
+	rpcAddr string
+}
+
+func (c runningSifchain) Name() string {
+	return c.name
+}
+
+func (c runningSifchain) ID() string {
+	return c.chainID
+}
+
+func (c runningSifchain) HealthCheck(ctx context.Context) error {
+	return healthCheck(ctx, c.rpcAddr)
+}
@@ -31,8 +31,38 @@ type Config struct {
 	// BinDir is the path where all binaries are present
 	BinDir string
 
-	// Network is the IP network for processes executed in tmux or direct targets
-	Network net.IP
+	// Network is the IP network for processes executed in tmux, direct or ssh targets
+	Network *net.IPNet
+
+	// Allocation is the mode of allocating addresses to apps in tmux and direct targets: ips | ports, empty means the one environment has been created with
+	Allocation string
+
+	// PortsIP is the IP all the apps listen on in ports allocation mode, nil means the one recorded in spec or 127.0.0.1
+	PortsIP net.IP
+
+	// SSHHost is the host apps are deployed to by ssh target
+	SSHHost string
+
+	// DockerNetwork is the /24 IP network created for apps deployed to docker target, it is chosen automatically if empty
+	DockerNetwork net.IP
+
+	// Registry is the registry container images are pulled from before falling back to the original one
+	Registry string
+
+	// Supervise means apps deployed to direct target are restarted whenever they exit
+	Supervise bool
+
+	// RestartPolicy tells how apps exited in tmux windows are restarted: manual | always
+	RestartPolicy string
+
+	// TMuxLayout is the layout of tmux session: windows | dashboard
+	TMuxLayout string
+
+	// WindowOrder lists apps whose tmux windows are placed first, in this order
+	WindowOrder []string
+
+	// DryRun means apps are not deployed for real, so spec is not stored and environment is not modified
+	DryRun bool
 
 	// TestingMode means we are in testing mode and deployment should not block execution
 	TestingMode bool
@@ -0,0 +1,142 @@
+package infra
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Export formats for spec
+const (
+	// ExportFormatJSON prints spec as JSON
+	ExportFormatJSON = "json"
+
+	// ExportFormatYAML prints spec as YAML
+	ExportFormatYAML = "yaml"
+
+	// ExportFormatEnv prints spec as shell exports
+	ExportFormatEnv = "env"
+
+	// ExportFormatDotEnv prints spec as dotenv file
+	ExportFormatDotEnv = "dotenv"
+)
+
+// Export returns part of spec pointed by selector (e.g. apps.sifchain.endpoints.rpc) in requested format.
+// Empty selector means whole spec. Scalar values are printed as they are in json and yaml formats.
+func (s *Spec) Export(selector, format string) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(s.String()), &value); err != nil {
+		return "", err
+	}
+
+	var path []string
+	if selector != "" {
+		path = strings.Split(selector, ".")
+	}
+	for i, key := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("selector %s: %s is not an object", selector, strings.Join(path[:i], "."))
+		}
+		if value, ok = m[key]; !ok {
+			return "", fmt.Errorf("selector %s: %s does not exist", selector, strings.Join(path[:i+1], "."))
+		}
+	}
+
+	switch format {
+	case ExportFormatJSON:
+		if scalar, ok := formatScalar(value); ok {
+			return scalar + "\n", nil
+		}
+		out, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(out) + "\n", nil
+	case ExportFormatYAML:
+		if scalar, ok := formatScalar(value); ok {
+			return scalar + "\n", nil
+		}
+		out, err := yaml.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(out), nil
+	case ExportFormatEnv, ExportFormatDotEnv:
+		vars := map[string]string{}
+		flatten(vars, path, value)
+		keys := make([]string, 0, len(vars))
+		for key := range vars {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b := &strings.Builder{}
+		for _, key := range keys {
+			if format == ExportFormatEnv {
+				b.WriteString("export " + key + "='" + strings.ReplaceAll(vars[key], "'", `'\''`) + "'\n")
+			} else {
+				b.WriteString(key + "=" + strconv.Quote(vars[key]) + "\n")
+			}
+		}
+		return b.String(), nil
+	default:
+		return "", fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func formatScalar(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "", true
+	default:
+		return "", false
+	}
+}
+
+func flatten(vars map[string]string, path []string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			flatten(vars, append(append([]string{}, path...), key), item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flatten(vars, append(append([]string{}, path...), strconv.Itoa(i)), item)
+		}
+	default:
+		scalar, _ := formatScalar(v)
+		vars[envVarName(path)] = scalar
+	}
+}
+
+var nonAlphanumericRegExp = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// envVarName converts path to the name of variable,
+// apps.sifchain-a.endpoints.rpc becomes SIFCHAIN_A_RPC and top level fields are prefixed with LOCALNET_
+func envVarName(path []string) string {
+	var parts []string
+	switch {
+	case len(path) > 0 && path[0] == "apps":
+		for i, part := range path[1:] {
+			if i == 1 && part == "endpoints" {
+				continue
+			}
+			parts = append(parts, part)
+		}
+	default:
+		parts = append([]string{"localnet"}, path...)
+	}
+	return strings.Trim(nonAlphanumericRegExp.ReplaceAllString(strings.ToUpper(strings.Join(parts, "_")), "_"), "_")
+}
@@ -0,0 +1,130 @@
+package infra
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Graph is the graph of dependencies between apps
+type Graph struct {
+	// Apps are the names of apps in the order they are defined in the set
+	Apps []string
+
+	// Dependencies maps name of app to names of apps it depends on
+	Dependencies map[string][]string
+}
+
+// Graph builds dependency graph of apps in the set
+func (s Set) Graph() Graph {
+	graph := Graph{
+		Apps:         make([]string, 0, len(s)),
+		Dependencies: map[string][]string{},
+	}
+	for _, app := range s {
+		graph.Apps = append(graph.Apps, app.Name())
+		depApp, ok := app.(DependencyCapable)
+		if !ok {
+			continue
+		}
+		for _, dep := range depApp.Dependencies() {
+			graph.Dependencies[app.Name()] = append(graph.Dependencies[app.Name()], dep.Name())
+		}
+	}
+	return graph
+}
+
+// Validate verifies that set may be deployed: names of apps are unique, all the dependencies are part of the set
+// and there are no dependency cycles
+func (s Set) Validate() error {
+	graph := s.Graph()
+	if err := graph.verifyUniqueness(); err != nil {
+		return err
+	}
+	inSet := map[string]bool{}
+	for _, app := range graph.Apps {
+		inSet[app] = true
+	}
+	for _, app := range graph.Apps {
+		for _, dep := range graph.Dependencies[app] {
+			if !inSet[dep] {
+				return fmt.Errorf("app %s depends on %s which is not part of the set", app, dep)
+			}
+		}
+	}
+	return graph.verifyAcyclic()
+}
+
+// String returns text representation of graph, each line contains app and apps it depends on
+func (g Graph) String() string {
+	b := &strings.Builder{}
+	for _, app := range g.Apps {
+		b.WriteString(app)
+		if deps := g.Dependencies[app]; len(deps) > 0 {
+			b.WriteString(" -> ")
+			b.WriteString(strings.Join(deps, ", "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// DOT returns representation of graph in DOT language, edges point from app to its dependencies
+func (g Graph) DOT(name string) string {
+	b := &strings.Builder{}
+	b.WriteString("digraph " + strconv.Quote(name) + " {\n")
+	for _, app := range g.Apps {
+		b.WriteString("  " + strconv.Quote(app) + ";\n")
+	}
+	for _, app := range g.Apps {
+		for _, dep := range g.Dependencies[app] {
+			b.WriteString("  " + strconv.Quote(app) + " -> " + strconv.Quote(dep) + ";\n")
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (g Graph) verifyUniqueness() error {
+	names := map[string]bool{}
+	for _, app := range g.Apps {
+		if names[app] {
+			return fmt.Errorf("app %s is defined more than once", app)
+		}
+		names[app] = true
+	}
+	return nil
+}
+
+func (g Graph) verifyAcyclic() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle detected: %s", strings.Join(path, " -> "))
+		}
+		state[name] = visiting
+		for _, dep := range g.Dependencies[name] {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, app := range g.Apps {
+		if err := visit(app, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package infra
+
+import (
+	"context"
+	"time"
+)
+
+// Health is the health of app reported to the user
+type Health string
+
+const (
+	// HealthHealthy means app passes its health check
+	HealthHealthy Health = "healthy"
+
+	// HealthUnhealthy means app is running but it fails its health check
+	HealthUnhealthy Health = "unhealthy"
+
+	// HealthRunning means app is running but it doesn't expose health check
+	HealthRunning Health = "running"
+
+	// HealthExited means app is not running
+	HealthExited Health = "exited"
+)
+
+// healthCheckInterval is the time between consecutive health checks of apps
+const healthCheckInterval = 5 * time.Second
+
+// HealthReporter represents target presenting health of apps to the user
+type HealthReporter interface {
+	// ReportHealth presents health of apps
+	ReportHealth(ctx context.Context, health map[string]Health) error
+
+	// ClearHealth removes health of apps presented previously, it is called when monitoring stops
+	ClearHealth(ctx context.Context) error
+}
+
+// MonitorHealth checks health of apps periodically and reports it until context is cancelled.
+// Environment is not locked while health is monitored, so spec is refreshed on each check to present apps
+// started, stopped and added by other localnet commands. Apps which are not part of the set are not health-checked.
+func MonitorHealth(ctx context.Context, target Target, set Set, spec *Spec, reporter HealthReporter) error {
+	setApps := map[string]App{}
+	for _, app := range set {
+		setApps[app.Name()] = app
+	}
+	for {
+		if err := spec.Refresh(); err != nil {
+			return err
+		}
+		spec.mu.Lock()
+		names := make([]string, 0, len(spec.Apps))
+		for name := range spec.Apps {
+			names = append(names, name)
+		}
+		spec.mu.Unlock()
+
+		health := map[string]Health{}
+		for _, name := range names {
+			status, err := target.AppStatus(ctx, name)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return err
+			}
+			health[name] = appHealth(ctx, setApps[name], status)
+		}
+		if err := reporter.ReportHealth(ctx, health); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(healthCheckInterval):
+		}
+	}
+}
+
+func appHealth(ctx context.Context, app App, status AppStatus) Health {
+	if status != AppStatusRunning {
+		return HealthExited
+	}
+	// App is nil if it is not part of the set
+	healthCheckApp, ok := app.(HealthCheckCapable)
+	if !ok {
+		return HealthRunning
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckInterval/2)
+	defer cancel()
+	if err := healthCheckApp.HealthCheck(ctx); err != nil {
+		return HealthUnhealthy
+	}
+	return HealthHealthy
+}
@@ -7,7 +7,6 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
-	"sync"
 	"text/template"
 	"time"
 
@@ -28,37 +27,15 @@ func PreprocessApp(ctx context.Context, ip net.IP, appDir string, app AppBase) e
 	// FIXME (wojciech): Preparing app directory should be done somewhere else
 	ensureDir(appDir + "/" + app.Name)
 
-	if len(app.Requires.Dependencies) > 0 {
-		waitCtx, waitCancel := context.WithTimeout(ctx, app.Requires.Timeout)
-		defer waitCancel()
-		if err := WaitUntilHealthy(waitCtx, app.Requires.Dependencies...); err != nil {
-			return err
-		}
-	}
-
-	data := struct {
-		IP net.IP
-	}{
-		IP: ip,
+	if err := waitForDependencies(ctx, app); err != nil {
+		return err
 	}
 
-	for i, arg := range app.Args {
-		tpl := template.Must(template.New("").Parse(arg))
-		buf := &bytes.Buffer{}
-		must.OK(tpl.Execute(buf, data))
-		app.Args[i] = buf.String()
-	}
+	data := newTplData(ip, app.Ports)
+	preprocessArgs(data, app)
 
 	for _, file := range app.Files {
-		if file.Content == nil && file.ContentFunc != nil {
-			file.Content = file.ContentFunc()
-		}
-		if file.Preprocess {
-			tpl := template.Must(template.New("").Parse(string(file.Content)))
-			buf := &bytes.Buffer{}
-			must.OK(tpl.Execute(buf, data))
-			file.Content = buf.Bytes()
-		}
+		file = preprocessFile(data, file)
 		must.OK(ioutil.WriteFile(file.Path, file.Content, 0o600))
 	}
 
@@ -68,10 +45,89 @@ func PreprocessApp(ctx context.Context, ip net.IP, appDir string, app AppBase) e
 	return nil
 }
 
-// PostprocessApp runs postprocessing of deployed app
-func PostprocessApp(ctx context.Context, ip net.IP, app AppBase) error {
+// ResumeApp prepares app which has been deployed and stopped before to be started again.
+// Files are not created and PreFunc is not called because state produced by them is kept by the target.
+func ResumeApp(ctx context.Context, ip net.IP, app AppBase) error {
+	if err := waitForDependencies(ctx, app); err != nil {
+		return err
+	}
+	preprocessArgs(newTplData(ip, app.Ports), app)
+	return nil
+}
+
+// RenderApp preprocesses args and files of app which is going to be deployed by something else than localnet.
+// Files are returned instead of being created, PreFunc is not called and dependencies are not awaited.
+func RenderApp(ip net.IP, app AppBase) []File {
+	data := newTplData(ip, app.Ports)
+	preprocessArgs(data, app)
+
+	files := make([]File, 0, len(app.Files))
+	for _, file := range app.Files {
+		files = append(files, preprocessFile(data, file))
+	}
+	return files
+}
+
+func waitForDependencies(ctx context.Context, app AppBase) error {
+	if len(app.Requires.Dependencies) == 0 {
+		return nil
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, app.Requires.Timeout)
+	defer waitCancel()
+	return WaitUntilHealthy(waitCtx, app.Requires.Dependencies...)
+}
+
+// tplData is the data passed to templates of args and files
+type tplData struct {
+	// IP is the IP assigned to app
+	IP net.IP
+
+	// Host is the IP formatted to be followed by port, IPv6 is enclosed in brackets
+	Host string
+
+	// Ports are the named ports allocated to app
+	Ports map[string]int
+}
+
+func newTplData(ip net.IP, ports map[string]int) tplData {
+	data := tplData{IP: ip, Ports: ports}
+	if ip != nil {
+		data.Host = ip.String()
+		if ip.To4() == nil {
+			data.Host = "[" + data.Host + "]"
+		}
+	}
+	return data
+}
+
+func preprocessFile(data tplData, file File) File {
+	if file.Content == nil && file.ContentFunc != nil {
+		file.Content = file.ContentFunc()
+	}
+	if file.Preprocess {
+		tpl := template.Must(template.New("").Parse(string(file.Content)))
+		buf := &bytes.Buffer{}
+		must.OK(tpl.Execute(buf, data))
+		file.Content = buf.Bytes()
+	}
+	return file
+}
+
+func preprocessArgs(data tplData, app AppBase) {
+	for i, arg := range app.Args {
+		tpl := template.Must(template.New("").Parse(arg))
+		buf := &bytes.Buffer{}
+		must.OK(tpl.Execute(buf, data))
+		app.Args[i] = buf.String()
+	}
+}
+
+// PostprocessApp runs postprocessing of deployed app.
+// Ports are taken from app because target stores the allocated ones there.
+func PostprocessApp(ctx context.Context, deployment Deployment, app AppBase) error {
+	deployment.Ports = app.Ports
 	if app.PostFunc != nil {
-		return app.PostFunc(ctx, Deployment{IP: ip})
+		return app.PostFunc(ctx, deployment)
 	}
 	return nil
 }
@@ -98,30 +154,3 @@ func WaitUntilHealthy(ctx context.Context, apps ...HealthCheckCapable) error {
 	}
 	return nil
 }
-
-// NewIPPool creates new IP pool
-func NewIPPool(startIP net.IP) *IPPool {
-	return &IPPool{
-		currentIP: startIP,
-	}
-}
-
-// IPPool generates IPs from pool
-type IPPool struct {
-	mu        sync.Mutex
-	currentIP net.IP
-}
-
-// Next returns next free IP from pool
-func (p *IPPool) Next() (net.IP, error) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	if p.currentIP[len(p.currentIP)-1] == 0xfe {
-		return nil, errors.New("no more IPs available")
-	}
-	p.currentIP[len(p.currentIP)-1]++
-	ip := make([]byte, len(p.currentIP))
-	copy(ip, p.currentIP)
-	return ip, nil
-}
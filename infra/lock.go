@@ -0,0 +1,49 @@
+package infra
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ErrEnvBusy is returned if environment is locked by another localnet process
+var ErrEnvBusy = errors.New("environment is busy, another localnet command is running there")
+
+// lockEnv acquires exclusive lock on environment's home dir.
+// Lock is released when returned file is closed or process exits.
+func lockEnv(homeDir string) (*os.File, error) {
+	lockFile, err := os.OpenFile(homeDir+"/.lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lockFile.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrEnvBusy, homeDir)
+		}
+		return nil, err
+	}
+	return lockFile, nil
+}
+
+// WriteFileAtomic writes file in a way that readers see either old or new content, never partially written one
+func WriteFileAtomic(path string, content []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,108 @@
+package manifests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/wojciech-sif/localnet/infra"
+	"gopkg.in/yaml.v3"
+)
+
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+	Networks map[string]composeNetwork `yaml:"networks"`
+	Configs  map[string]composeConfig  `yaml:"configs,omitempty"`
+}
+
+type composeService struct {
+	Image      string                           `yaml:"image"`
+	Entrypoint []string                         `yaml:"entrypoint,omitempty"`
+	Command    []string                         `yaml:"command,omitempty"`
+	Hostname   string                           `yaml:"hostname"`
+	DependsOn  []string                         `yaml:"depends_on,omitempty"` // nolint: tagliatelle
+	Networks   map[string]composeServiceNetwork `yaml:"networks"`
+	Volumes    []string                         `yaml:"volumes,omitempty"`
+	Configs    []composeServiceConfig           `yaml:"configs,omitempty"`
+}
+
+type composeServiceNetwork struct {
+	IPv4Address string `yaml:"ipv4_address"` // nolint: tagliatelle
+}
+
+type composeServiceConfig struct {
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+}
+
+type composeNetwork struct {
+	Labels map[string]string `yaml:"labels"`
+	IPAM   composeIPAM       `yaml:"ipam"`
+}
+
+type composeIPAM struct {
+	Config []composeIPAMConfig `yaml:"config"`
+}
+
+type composeIPAMConfig struct {
+	Subnet string `yaml:"subnet"`
+}
+
+type composeConfig struct {
+	Content string `yaml:"content"`
+}
+
+// Compose renders set as docker-compose file.
+// Containers get IPs from /24 network, images of binaries are the ones built by docker target.
+func Compose(ctx context.Context, config infra.Config, spec *infra.Spec, set infra.Set, network net.IP) ([]byte, error) {
+	apps, err := record(ctx, config, spec, set, network, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	networkName := "localnet-" + config.EnvName
+	file := composeFile{
+		Services: map[string]composeService{},
+		Networks: map[string]composeNetwork{
+			networkName: {
+				Labels: map[string]string{"finance.sifchain.localnet.env": config.EnvName},
+				IPAM: composeIPAM{
+					Config: []composeIPAMConfig{{Subnet: (&net.IPNet{IP: network.To4(), Mask: net.CIDRMask(24, 32)}).String()}},
+				},
+			},
+		},
+		Configs: map[string]composeConfig{},
+	}
+	for _, app := range apps {
+		service := composeService{
+			Image:      app.Image,
+			Entrypoint: app.Entrypoint,
+			Command:    app.Args,
+			Hostname:   app.Name,
+			DependsOn:  app.Dependencies,
+			Networks: map[string]composeServiceNetwork{
+				networkName: {IPv4Address: app.IP.String()},
+			},
+		}
+		for _, volume := range app.Volumes {
+			service.Volumes = append(service.Volumes, volume+":"+volume)
+		}
+		for i, f := range app.Files {
+			configName := fmt.Sprintf("%s-%d", app.Name, i)
+			file.Configs[configName] = composeConfig{Content: string(f.Content)}
+			service.Configs = append(service.Configs, composeServiceConfig{Source: configName, Target: f.Path})
+		}
+		file.Services[app.Name] = service
+	}
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(file); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
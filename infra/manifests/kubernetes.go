@@ -0,0 +1,206 @@
+package manifests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/wojciech-sif/localnet/infra"
+	"gopkg.in/yaml.v3"
+)
+
+type k8sMeta struct {
+	Name        string            `yaml:"name"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type k8sConfigMap struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMeta           `yaml:"metadata"`
+	Data       map[string]string `yaml:"data"`
+}
+
+type k8sService struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   k8sMeta        `yaml:"metadata"`
+	Spec       k8sServiceSpec `yaml:"spec"`
+}
+
+type k8sServiceSpec struct {
+	ClusterIP string            `yaml:"clusterIP"`
+	Selector  map[string]string `yaml:"selector"`
+	Ports     []k8sServicePort  `yaml:"ports"`
+}
+
+type k8sServicePort struct {
+	Name string `yaml:"name"`
+	Port int    `yaml:"port"`
+}
+
+type k8sDeployment struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMeta           `yaml:"metadata"`
+	Spec       k8sDeploymentSpec `yaml:"spec"`
+}
+
+type k8sDeploymentSpec struct {
+	Replicas int         `yaml:"replicas"`
+	Selector k8sSelector `yaml:"selector"`
+	Template k8sPod      `yaml:"template"`
+}
+
+type k8sSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+type k8sPod struct {
+	Metadata k8sMeta    `yaml:"metadata"`
+	Spec     k8sPodSpec `yaml:"spec"`
+}
+
+type k8sPodSpec struct {
+	Hostname   string         `yaml:"hostname"`
+	Containers []k8sContainer `yaml:"containers"`
+	Volumes    []k8sVolume    `yaml:"volumes,omitempty"`
+}
+
+type k8sContainer struct {
+	Name         string           `yaml:"name"`
+	Image        string           `yaml:"image"`
+	Command      []string         `yaml:"command,omitempty"`
+	Args         []string         `yaml:"args,omitempty"`
+	VolumeMounts []k8sVolumeMount `yaml:"volumeMounts,omitempty"`
+}
+
+type k8sVolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	SubPath   string `yaml:"subPath,omitempty"`
+}
+
+type k8sVolume struct {
+	Name      string        `yaml:"name"`
+	HostPath  *k8sHostPath  `yaml:"hostPath,omitempty"`
+	ConfigMap *k8sConfigRef `yaml:"configMap,omitempty"`
+}
+
+type k8sHostPath struct {
+	Path string `yaml:"path"`
+	Type string `yaml:"type"`
+}
+
+type k8sConfigRef struct {
+	Name string `yaml:"name"`
+}
+
+var nonDNSRegExp = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Kubernetes renders set as Kubernetes manifests: deployment and service for each app and config map for files generated for it.
+// Apps listen on all interfaces of their pods and reach each other using IPs from /24 network assigned to services,
+// so network has to be a part of service CIDR configured in the cluster.
+// Directories required by apps are mounted from the host, so they have to be prepared on the nodes.
+func Kubernetes(ctx context.Context, config infra.Config, spec *infra.Spec, set infra.Set, network net.IP) ([]byte, error) {
+	apps, err := record(ctx, config, spec, set, network, net.IPv4zero)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []interface{}
+	for _, app := range apps {
+		name := nonDNSRegExp.ReplaceAllString(strings.ToLower(config.EnvName+"-"+app.Name), "-")
+		labels := map[string]string{
+			"app.kubernetes.io/name":        app.Name,
+			"finance.sifchain.localnet.env": config.EnvName,
+		}
+
+		container := k8sContainer{
+			Name:    nonDNSRegExp.ReplaceAllString(strings.ToLower(app.Name), "-"),
+			Image:   app.Image,
+			Command: app.Entrypoint,
+			Args:    app.Args,
+		}
+		pod := k8sPodSpec{Hostname: container.Name}
+		for i, volume := range app.Volumes {
+			volumeName := fmt.Sprintf("dir-%d", i)
+			container.VolumeMounts = append(container.VolumeMounts, k8sVolumeMount{Name: volumeName, MountPath: volume})
+			pod.Volumes = append(pod.Volumes, k8sVolume{Name: volumeName, HostPath: &k8sHostPath{Path: volume, Type: "Directory"}})
+		}
+		if len(app.Files) > 0 {
+			configMap := k8sConfigMap{
+				APIVersion: "v1",
+				Kind:       "ConfigMap",
+				Metadata:   k8sMeta{Name: name + "-files", Labels: labels},
+				Data:       map[string]string{},
+			}
+			for i, f := range app.Files {
+				key := fmt.Sprintf("%d-%s", i, filepath.Base(f.Path))
+				configMap.Data[key] = string(f.Content)
+				container.VolumeMounts = append(container.VolumeMounts, k8sVolumeMount{Name: "files", MountPath: f.Path, SubPath: key})
+			}
+			pod.Volumes = append(pod.Volumes, k8sVolume{Name: "files", ConfigMap: &k8sConfigRef{Name: configMap.Metadata.Name}})
+			objects = append(objects, configMap)
+		}
+		pod.Containers = []k8sContainer{container}
+
+		meta := k8sMeta{Name: name, Labels: labels}
+		if len(app.Dependencies) > 0 {
+			// Kubernetes doesn't order deployments, apps are expected to retry until dependencies are ready
+			meta.Annotations = map[string]string{"finance.sifchain.localnet/depends-on": strings.Join(app.Dependencies, ",")}
+		}
+		objects = append(objects, k8sDeployment{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Metadata:   meta,
+			Spec: k8sDeploymentSpec{
+				Replicas: 1,
+				Selector: k8sSelector{MatchLabels: labels},
+				Template: k8sPod{
+					Metadata: k8sMeta{Name: name, Labels: labels},
+					Spec:     pod,
+				},
+			},
+		})
+
+		if len(app.Ports) == 0 {
+			continue
+		}
+		service := k8sService{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Metadata:   k8sMeta{Name: name, Labels: labels},
+			Spec: k8sServiceSpec{
+				ClusterIP: app.IP.String(),
+				Selector:  labels,
+			},
+		}
+		for portName, port := range app.Ports {
+			service.Spec.Ports = append(service.Spec.Ports, k8sServicePort{Name: portName, Port: port})
+		}
+		sort.Slice(service.Spec.Ports, func(i, j int) bool {
+			return service.Spec.Ports[i].Name < service.Spec.Ports[j].Name
+		})
+		objects = append(objects, service)
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	for _, object := range objects {
+		if err := encoder.Encode(object); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,81 @@
+package manifests_test
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/wojciech-sif/localnet"
+	"github.com/wojciech-sif/localnet/infra"
+	"github.com/wojciech-sif/localnet/infra/apps"
+	"github.com/wojciech-sif/localnet/infra/manifests"
+	"github.com/wojciech-sif/localnet/lib/logger"
+)
+
+var update = flag.Bool("update", false, "regenerate golden files")
+
+type renderFunc func(ctx context.Context, config infra.Config, spec *infra.Spec, set infra.Set, network net.IP) ([]byte, error)
+
+func TestGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		set    func(af *apps.Factory) infra.Set
+		render renderFunc
+	}{
+		{golden: "full.compose.yaml", set: localnet.FullSet, render: manifests.Compose},
+		{golden: "full.kubernetes.yaml", set: localnet.FullSet, render: manifests.Kubernetes},
+		{golden: "validators.compose.yaml", set: validatorsSet, render: manifests.Compose},
+		{golden: "validators.kubernetes.yaml", set: validatorsSet, render: manifests.Kubernetes},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.golden, func(t *testing.T) {
+			ctx := logger.WithLogger(context.Background(), logger.New())
+			config := infra.Config{
+				EnvName:    "localnet",
+				SetName:    "test",
+				Target:     "docker",
+				HomeDir:    "/localnet",
+				AppDir:     "/localnet/app",
+				LogDir:     "/localnet/logs",
+				WrapperDir: t.TempDir(),
+				BinDir:     "/localnet/bin",
+				DryRun:     true,
+			}
+			spec := infra.NewVolatileSpec(config)
+			set := tt.set(apps.NewFactory(config, spec))
+
+			out, err := tt.render(ctx, config, spec, set, net.IPv4(10, 96, 86, 0).To4())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			goldenFile := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := ioutil.WriteFile(goldenFile, out, 0o600); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := ioutil.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != string(expected) {
+				t.Errorf("output differs from %s, run tests with -update to regenerate it\ngot:\n%s", goldenFile, out)
+			}
+		})
+	}
+}
+
+// validatorsSet is the network of validators, they depend on their peers
+func validatorsSet(af *apps.Factory) infra.Set {
+	var set infra.Set
+	for _, node := range af.SifchainNetwork("sifchain", 3) {
+		set = append(set, node)
+	}
+	return set
+}
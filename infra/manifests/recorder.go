@@ -0,0 +1,187 @@
+package manifests
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/wojciech-sif/localnet/infra"
+)
+
+// app is the definition of app recorded instead of being deployed
+type app struct {
+	Name         string
+	Image        string
+	Entrypoint   []string
+	Args         []string
+	IP           net.IP
+	Files        []infra.File
+	Volumes      []string
+	Dependencies []string
+	Ports        map[string]int
+}
+
+// newRecorder creates new recorder.
+// IPs are taken from network, if bindIP is not nil apps listen on it instead of the IP assigned to them.
+// Graph provides dependencies of apps which are not expressed as prerequisites, e.g. peers of validators.
+func newRecorder(config infra.Config, spec *infra.Spec, graph infra.Graph, network, bindIP net.IP) *recorder {
+	ipPool := infra.NewIPPool(&net.IPNet{IP: network.To4(), Mask: net.CIDRMask(24, 32)}, spec)
+	// The first IP in the network is reserved for the gateway
+	gateway := append(net.IP{}, network.To4()...)
+	gateway[len(gateway)-1]++
+	ipPool.Reserve(gateway)
+	return &recorder{
+		config: config,
+		spec:   spec,
+		ipPool: ipPool,
+		graph:  graph,
+		bindIP: bindIP,
+		apps:   map[string]*app{},
+	}
+}
+
+// recorder is the app target recording definitions of apps instead of deploying them
+type recorder struct {
+	config infra.Config
+	spec   *infra.Spec
+	ipPool *infra.IPPool
+	graph  infra.Graph
+	bindIP net.IP
+
+	mu   sync.Mutex
+	apps map[string]*app
+}
+
+// DeployBinary records binary app, binary is expected to be delivered in the image built by docker target
+func (r *recorder) DeployBinary(ctx context.Context, binary infra.Binary) error {
+	return r.record(ctx, binary.AppBase, r.config.EnvName+"/"+binary.Name+":latest", []string{binary.Path})
+}
+
+// DeployContainer records container app
+func (r *recorder) DeployContainer(ctx context.Context, container infra.Container) error {
+	tag := container.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	return r.record(ctx, container.AppBase, container.Image+":"+tag, nil)
+}
+
+// Apps returns recorded apps sorted by name
+func (r *recorder) Apps() []*app {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	apps := make([]*app, 0, len(r.apps))
+	for _, a := range r.apps {
+		apps = append(apps, a)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].Name < apps[j].Name
+	})
+	return apps
+}
+
+func (r *recorder) record(ctx context.Context, appBase infra.AppBase, image string, entrypoint []string) error {
+	ip, err := r.ipPool.Allocate(appBase.Name)
+	if err != nil {
+		return err
+	}
+	bindIP := ip
+	if r.bindIP != nil {
+		bindIP = r.bindIP
+	}
+
+	files := infra.RenderApp(bindIP, appBase)
+
+	// Files are delivered separately, so only directories are mounted
+	filePaths := map[string]bool{}
+	for _, file := range files {
+		filePaths[file.Path] = true
+	}
+	var volumes []string
+	for _, path := range appBase.Copy {
+		if !filePaths[path] && path != entrypointPath(entrypoint) {
+			volumes = append(volumes, path)
+		}
+	}
+
+	dependencies := append([]string{}, r.graph.Dependencies[appBase.Name]...)
+	for _, dep := range appBase.Requires.Dependencies {
+		dependencies = append(dependencies, dep.Name())
+	}
+	dependencies = uniqueSorted(dependencies)
+
+	// PostFunc records endpoints of the app in spec and configures apps depending on this one
+	if err := infra.PostprocessApp(ctx, infra.Deployment{IP: ip}, appBase); err != nil {
+		return err
+	}
+	appDesc, err := r.spec.App(appBase.Name)
+	if err != nil {
+		return err
+	}
+	ports := map[string]int{}
+	for name, endpoint := range appDesc.Endpoints {
+		_, portStr, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			continue
+		}
+		ports[name] = port
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.apps[appBase.Name] = &app{
+		Name:         appBase.Name,
+		Image:        image,
+		Entrypoint:   entrypoint,
+		Args:         appBase.Args,
+		IP:           ip,
+		Files:        files,
+		Volumes:      volumes,
+		Dependencies: dependencies,
+		Ports:        ports,
+	}
+	return nil
+}
+
+func uniqueSorted(values []string) []string {
+	unique := map[string]bool{}
+	var res []string
+	for _, value := range values {
+		if !unique[value] {
+			unique[value] = true
+			res = append(res, value)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func entrypointPath(entrypoint []string) string {
+	if len(entrypoint) == 0 {
+		return ""
+	}
+	return entrypoint[0]
+}
+
+// record deploys set to the recorder and returns recorded apps
+func record(ctx context.Context, config infra.Config, spec *infra.Spec, set infra.Set, network, bindIP net.IP) ([]*app, error) {
+	r := newRecorder(config, spec, set.Graph(), network, bindIP)
+	// Apps are deployed concurrently, IPs are allocated upfront so they don't depend on the order of deployments
+	for _, app := range set {
+		if _, err := r.ipPool.Allocate(app.Name()); err != nil {
+			return nil, err
+		}
+	}
+	if err := set.Deploy(ctx, r, spec); err != nil {
+		return nil, err
+	}
+	return r.Apps(), nil
+}
@@ -0,0 +1,117 @@
+package infra
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// SpecVersion is the version of spec format produced by this version of localnet
+const SpecVersion = 3
+
+// specMigrations contains functions migrating spec from the version equal to index to the next one
+var specMigrations = []func(spec map[string]interface{}) error{
+	migrateSpecV0,
+	migrateSpecV1,
+	migrateSpecV2,
+}
+
+// migrateSpec upgrades raw spec to the current version
+func migrateSpec(specRaw []byte) ([]byte, error) {
+	spec := map[string]interface{}{}
+	if err := json.Unmarshal(specRaw, &spec); err != nil {
+		return nil, err
+	}
+
+	version := 0
+	if versionRaw, exists := spec["version"]; exists {
+		versionFloat, ok := versionRaw.(float64)
+		if !ok {
+			return nil, fmt.Errorf("invalid spec version: %v", versionRaw)
+		}
+		version = int(versionFloat)
+	}
+	if version > SpecVersion {
+		return nil, fmt.Errorf("environment has been created by newer version of localnet (spec version: %d, supported version: %d), upgrade localnet or destroy the environment using the newer one", version, SpecVersion)
+	}
+	if version == SpecVersion {
+		return specRaw, nil
+	}
+
+	for ; version < SpecVersion; version++ {
+		if err := specMigrations[version](spec); err != nil {
+			return nil, fmt.Errorf("migrating spec from version %d failed: %w", version, err)
+		}
+	}
+	spec["version"] = SpecVersion
+	return json.Marshal(spec)
+}
+
+// migrateSpecV0 migrates spec created before versioning was introduced.
+// At that time chain ID of sifchain was always equal to the name of app.
+func migrateSpecV0(spec map[string]interface{}) error {
+	apps, _ := spec["apps"].(map[string]interface{})
+	for name, appRaw := range apps {
+		app, ok := appRaw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid description of app %s", name)
+		}
+		if app["type"] != "sifchain" {
+			continue
+		}
+		params, _ := app["params"].(map[string]interface{})
+		if params == nil {
+			params = map[string]interface{}{}
+			app["params"] = params
+		}
+		if _, exists := params["chainID"]; !exists {
+			params["chainID"] = name
+		}
+	}
+	return nil
+}
+
+// migrateSpecV1 copies IPs recorded by apps to IP allocations, so apps keep them once they are deployed again
+func migrateSpecV1(spec map[string]interface{}) error {
+	apps, _ := spec["apps"].(map[string]interface{})
+	ips := map[string]interface{}{}
+	for name, appRaw := range apps {
+		app, ok := appRaw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid description of app %s", name)
+		}
+		if ip, exists := app["ip"]; exists {
+			ips[name] = ip
+		}
+	}
+	if len(ips) > 0 {
+		spec["ips"] = ips
+	}
+	return nil
+}
+
+// migrateSpecV2 records network environment has claimed.
+// At that time IPs were taken from /24 network, so it is derived from IPs allocated to apps.
+// Docker target used fixed network not claimed by environments, so it is left for docker target to read it back from docker.
+func migrateSpecV2(spec map[string]interface{}) error {
+	if target, _ := spec["target"].(string); target == "docker" {
+		return nil
+	}
+	ips, _ := spec["ips"].(map[string]interface{})
+	names := make([]string, 0, len(ips))
+	for name := range ips {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	ipStr, _ := ips[names[0]].(string)
+	ip := net.ParseIP(ipStr).To4()
+	if ip == nil {
+		return fmt.Errorf("invalid IP of app %s: %v", names[0], ips[names[0]])
+	}
+	spec["network"] = (&net.IPNet{IP: ip.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	return nil
+}
@@ -0,0 +1,341 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+var (
+	// autoNetworkBase is the first network allocated automatically to environment, next ones are the following /24 networks
+	autoNetworkBase = &net.IPNet{IP: net.IPv4(127, 1, 0, 0).To4(), Mask: net.CIDRMask(24, 32)}
+
+	// autoDockerNetworkBase is the first network allocated automatically to environment deployed to docker
+	autoDockerNetworkBase = &net.IPNet{IP: net.IPv4(10, 86, 0, 0).To4(), Mask: net.CIDRMask(24, 32)}
+)
+
+// ParseNetwork parses network of IPs allocated to apps.
+// Network is given in CIDR notation, single IP is accepted for backward compatibility and means /24 network for IPv4
+// and /120 for IPv6. Nil is returned for empty string, it happens for commands which don't deploy apps.
+func ParseNetwork(network string) (*net.IPNet, error) {
+	if network == "" {
+		return nil, nil
+	}
+	if ip := net.ParseIP(network); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}, nil
+		}
+		return &net.IPNet{IP: ip.Mask(net.CIDRMask(120, 128)), Mask: net.CIDRMask(120, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q: %w", network, err)
+	}
+	return ipNet, nil
+}
+
+// ClaimNetwork returns network IPs are allocated to apps of the environment from and records it in spec.
+// Network recorded in spec is used if environment has claimed it already, network passed in config has to be the same one then.
+// Otherwise the one passed in config is claimed
+// or, if it is not set, the first /24 network not claimed by other environments existing in home directory is chosen.
+// Network stays claimed until environment is destroyed.
+func ClaimNetwork(ctx context.Context, config Config, spec *Spec) (*net.IPNet, error) {
+	return claimNetwork(ctx, config, spec, config.Network, autoNetworkBase)
+}
+
+// ClaimDockerNetwork returns /24 network created in docker for the environment and records it in spec.
+// It works the same way as ClaimNetwork, but network is taken from docker network passed in config
+// and networks chosen automatically start from 10.86.0.0/24.
+func ClaimDockerNetwork(ctx context.Context, config Config, spec *Spec) (*net.IPNet, error) {
+	var requested *net.IPNet
+	if config.DockerNetwork != nil {
+		requested = &net.IPNet{IP: config.DockerNetwork.To4().Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+	}
+	return claimNetwork(ctx, config, spec, requested, autoDockerNetworkBase)
+}
+
+func claimNetwork(ctx context.Context, config Config, spec *Spec, requested, autoBase *net.IPNet) (*net.IPNet, error) {
+	if spec.Network != "" {
+		network, err := ParseNetwork(spec.Network)
+		if err != nil {
+			return nil, err
+		}
+		if requested != nil && requested.String() != network.String() {
+			return nil, fmt.Errorf("environment %s has claimed network %s already, it conflicts with requested network %s, destroy environment to change it",
+				config.EnvName, network, requested)
+		}
+		return network, nil
+	}
+
+	var network *net.IPNet
+	err := claim(config, spec, func(claimed map[string]claims) error {
+		network = requested
+		if network == nil {
+			var err error
+			network, err = freeNetwork(autoBase, claimed)
+			if err != nil {
+				return err
+			}
+		}
+		for _, env := range sortedKeys(claimed) {
+			if claimedNetwork := claimed[env].Network; claimedNetwork != nil && networksOverlap(network, claimedNetwork) {
+				return fmt.Errorf("network %s overlaps with network %s claimed by environment %s", network, claimedNetwork, env)
+			}
+		}
+		spec.Network = network.String()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return network, nil
+}
+
+// claim calls choose with resources claimed by other environments existing in home directory,
+// choose records resource claimed by the environment in spec.
+// Environments are created one by one, so two of them never claim the same resource.
+// Spec is saved right away, so other environments see the claim.
+func claim(config Config, spec *Spec, choose func(claimed map[string]claims) error) error {
+	homeRoot := filepath.Dir(config.HomeDir)
+	lock, err := lockClaims(homeRoot)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	claimed, err := readClaims(homeRoot, config.EnvName)
+	if err != nil {
+		return err
+	}
+	if err := choose(claimed); err != nil {
+		return err
+	}
+	if spec.specFile == "" {
+		return nil
+	}
+	return spec.Save()
+}
+
+// lockClaims locks the file protecting networks and port ranges claimed by environments existing in home directory
+func lockClaims(homeRoot string) (*os.File, error) {
+	lock, err := os.OpenFile(homeRoot+"/.networks.lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		_ = lock.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// claims are the resources claimed by environment
+type claims struct {
+	Network   *net.IPNet
+	PortRange *PortRange
+}
+
+// readClaims returns resources claimed by environments existing in home directory, except the one passed
+func readClaims(homeRoot, exceptEnv string) (map[string]claims, error) {
+	entries, err := ioutil.ReadDir(homeRoot)
+	if err != nil {
+		return nil, err
+	}
+	claimed := map[string]claims{}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == exceptEnv {
+			continue
+		}
+		specRaw, err := ioutil.ReadFile(filepath.Join(homeRoot, entry.Name(), "spec.json"))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		// Networks are not recorded in specs created by older versions of localnet
+		specRaw, err = migrateSpec(specRaw)
+		if err != nil {
+			return nil, fmt.Errorf("reading spec of environment %s failed: %w", entry.Name(), err)
+		}
+		spec := struct {
+			Network   string     `json:"network"`
+			PortRange *PortRange `json:"portRange"`
+		}{}
+		if err := json.Unmarshal(specRaw, &spec); err != nil {
+			return nil, fmt.Errorf("reading spec of environment %s failed: %w", entry.Name(), err)
+		}
+		if spec.Network == "" && spec.PortRange == nil {
+			continue
+		}
+		network, err := ParseNetwork(spec.Network)
+		if err != nil {
+			return nil, err
+		}
+		claimed[entry.Name()] = claims{Network: network, PortRange: spec.PortRange}
+	}
+	return claimed, nil
+}
+
+// freeNetwork returns the first /24 network starting from autoBase which doesn't overlap with claimed ones
+func freeNetwork(autoBase *net.IPNet, claimed map[string]claims) (*net.IPNet, error) {
+	network := &net.IPNet{IP: copyIP(autoBase.IP), Mask: autoBase.Mask}
+	for network.IP[0] == autoBase.IP[0] {
+		free := true
+		for _, c := range claimed {
+			if c.Network != nil && networksOverlap(network, c.Network) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return network, nil
+		}
+		network = &net.IPNet{IP: incrementIP(lastIP(network)), Mask: network.Mask}
+	}
+	return nil, errors.New("all the networks available for environments are claimed, destroy unused environments")
+}
+
+func networksOverlap(network1, network2 *net.IPNet) bool {
+	return network1.Contains(network2.IP) || network2.Contains(network1.IP)
+}
+
+// lastIP returns the last IP belonging to network
+func lastIP(network *net.IPNet) net.IP {
+	ip := normalizeIP(network.IP)
+	for i := range ip {
+		ip[i] |= ^network.Mask[i]
+	}
+	return ip
+}
+
+func sortedKeys(claimed map[string]claims) []string {
+	keys := make([]string, 0, len(claimed))
+	for key := range claimed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// JoinHostPort returns address composed of IP and port, IPv6 is enclosed in brackets
+func JoinHostPort(ip net.IP, port int) string {
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
+
+// NewIPPool creates new pool allocating IPs from network.
+// IPs allocated to apps are recorded in spec, so app gets the same IP every time it is deployed.
+func NewIPPool(network *net.IPNet, spec *Spec) *IPPool {
+	return &IPPool{
+		network:  network,
+		spec:     spec,
+		reserved: map[string]bool{},
+	}
+}
+
+// IPPool allocates IPs to apps
+type IPPool struct {
+	network *net.IPNet
+	spec    *Spec
+
+	mu       sync.Mutex
+	reserved map[string]bool
+}
+
+// Reserve marks IP as used, so it is never allocated by the pool
+func (p *IPPool) Reserve(ip net.IP) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reserved[ip.String()] = true
+}
+
+// Allocate returns IP allocated to the app.
+// IP recorded in spec is reused if it belongs to the network, otherwise the first free one is allocated.
+// The first and the last IPs in the network are never allocated, they are the network and broadcast addresses in IPv4.
+func (p *IPPool) Allocate(name string) (net.IP, error) {
+	if p.network == nil {
+		return nil, fmt.Errorf("network is not configured, IP can't be allocated to app %s", name)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.spec.mu.Lock()
+	defer p.spec.mu.Unlock()
+
+	if ip, exists := p.spec.IPs[name]; exists && p.network.Contains(ip) {
+		return copyIP(ip), nil
+	}
+
+	used := map[string]bool{}
+	for ipStr := range p.reserved {
+		used[ipStr] = true
+	}
+	for appName, ip := range p.spec.IPs {
+		if appName != name {
+			used[ip.String()] = true
+		}
+	}
+
+	ip := normalizeIP(p.network.IP)
+	for {
+		ip = incrementIP(ip)
+		if !p.network.Contains(ip) || isLastIP(p.network, ip) {
+			return nil, fmt.Errorf("no more IPs available in network %s", p.network)
+		}
+		if !used[ip.String()] {
+			break
+		}
+	}
+	if p.spec.IPs == nil {
+		p.spec.IPs = map[string]net.IP{}
+	}
+	p.spec.IPs[name] = ip
+	return copyIP(ip), nil
+}
+
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return copyIP(ip4)
+	}
+	return copyIP(ip)
+}
+
+func copyIP(ip net.IP) net.IP {
+	return append(net.IP{}, ip...)
+}
+
+// incrementIP returns IP following the one passed
+func incrementIP(ip net.IP) net.IP {
+	next := copyIP(ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// isLastIP returns true if all the bits of host part of IP are set
+func isLastIP(network *net.IPNet, ip net.IP) bool {
+	ip = normalizeIP(ip)
+	if len(ip) != len(network.Mask) {
+		return false
+	}
+	for i := range ip {
+		if ip[i]|network.Mask[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
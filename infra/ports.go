@@ -0,0 +1,269 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+)
+
+const (
+	// AllocationIPs means each app gets its own IP and listens on default ports
+	AllocationIPs = "ips"
+
+	// AllocationPorts means all the apps listen on the same IP and each one gets its own block of ports
+	AllocationPorts = "ports"
+)
+
+const (
+	// autoPortRangeBase is the first port of range claimed automatically by environment
+	autoPortRangeBase = 20000
+
+	// autoPortRangeLimit is the first port never claimed, ports above are used by linux as ephemeral ones
+	autoPortRangeLimit = 32768
+
+	// portRangeSize is the number of ports claimed by environment
+	portRangeSize = 500
+
+	// portBlockSize is the number of ports allocated to each app
+	portBlockSize = 10
+)
+
+// defaultPortsIP is the IP apps listen on in ports allocation mode if other one is not requested
+var defaultPortsIP = net.IPv4(127, 0, 0, 1)
+
+// PortRange is the range of ports claimed by environment on single IP
+type PortRange struct {
+	// IP is the IP all the apps listen on
+	IP net.IP `json:"ip"`
+
+	// First is the first port in the range
+	First int `json:"first"`
+
+	// Last is the last port in the range
+	Last int `json:"last"`
+}
+
+// String returns range in the form of ip:first-last
+func (r PortRange) String() string {
+	return fmt.Sprintf("%s-%d", JoinHostPort(r.IP, r.First), r.Last)
+}
+
+func (r PortRange) overlaps(r2 PortRange) bool {
+	return r.First <= r2.Last && r2.First <= r.Last
+}
+
+// ClaimAddresses returns pool allocating addresses to apps of the environment.
+// Allocation mode used when environment claimed network or port range for the first time is kept until it is destroyed,
+// requesting different one is an error.
+func ClaimAddresses(ctx context.Context, config Config, spec *Spec) (*AddressPool, error) {
+	allocation := config.Allocation
+	switch {
+	case spec.Network != "":
+		allocation = AllocationIPs
+	case spec.PortRange != nil:
+		allocation = AllocationPorts
+	}
+	if config.Allocation != "" && config.Allocation != allocation {
+		return nil, fmt.Errorf("environment %s has claimed addresses in %s allocation mode already, it conflicts with requested mode %s, destroy environment to change it",
+			config.EnvName, allocation, config.Allocation)
+	}
+
+	switch allocation {
+	case AllocationIPs, "":
+		network, err := ClaimNetwork(ctx, config, spec)
+		if err != nil {
+			return nil, err
+		}
+		return &AddressPool{ipPool: NewIPPool(network, spec)}, nil
+	case AllocationPorts:
+		portRange, err := ClaimPortRange(ctx, config, spec)
+		if err != nil {
+			return nil, err
+		}
+		return &AddressPool{portPool: NewPortPool(portRange, spec)}, nil
+	default:
+		return nil, fmt.Errorf("unknown allocation mode %q", allocation)
+	}
+}
+
+// AddressPool allocates addresses to apps using one of the allocation modes
+type AddressPool struct {
+	ipPool   *IPPool
+	portPool *PortPool
+}
+
+// Allocate returns IP and ports allocated to the app.
+// Ports are the named ports app listens on, in ips mode they are returned unchanged.
+func (p *AddressPool) Allocate(name string, ports map[string]int) (net.IP, map[string]int, error) {
+	if p.portPool == nil {
+		ip, err := p.ipPool.Allocate(name)
+		return ip, ports, err
+	}
+	ports, err := p.portPool.Allocate(name, ports)
+	if err != nil {
+		return nil, nil, err
+	}
+	return copyIP(p.portPool.portRange.IP), ports, nil
+}
+
+// ClaimPortRange returns range of ports allocated to apps of the environment and records it in spec.
+// Range recorded in spec is used if environment has claimed it already, IP passed in config has to be the same one then.
+// Otherwise the first range on IP passed in config, or 127.0.0.1 if it is not set, not claimed
+// by other environments existing in home directory is chosen. Range stays claimed until environment is destroyed.
+func ClaimPortRange(ctx context.Context, config Config, spec *Spec) (PortRange, error) {
+	if spec.PortRange != nil {
+		if config.PortsIP != nil && !config.PortsIP.Equal(spec.PortRange.IP) {
+			return PortRange{}, fmt.Errorf("environment %s has claimed port range %s already, it conflicts with requested IP %s, destroy environment to change it",
+				config.EnvName, spec.PortRange, config.PortsIP)
+		}
+		return *spec.PortRange, nil
+	}
+	portsIP := config.PortsIP
+	if portsIP == nil {
+		portsIP = defaultPortsIP
+	}
+
+	var portRange PortRange
+	err := claim(config, spec, func(claimed map[string]claims) error {
+		var err error
+		portRange, err = freePortRange(portsIP, claimed)
+		if err != nil {
+			return err
+		}
+		spec.PortRange = &portRange
+		return nil
+	})
+	if err != nil {
+		return PortRange{}, err
+	}
+	return portRange, nil
+}
+
+// freePortRange returns the first range starting from autoPortRangeBase which doesn't overlap with claimed ones.
+// Ranges claimed on different IPs are taken into account too, because apps listening on all the interfaces
+// would conflict with them.
+func freePortRange(ip net.IP, claimed map[string]claims) (PortRange, error) {
+	for first := autoPortRangeBase; first+portRangeSize <= autoPortRangeLimit; first += portRangeSize {
+		portRange := PortRange{IP: normalizeIP(ip), First: first, Last: first + portRangeSize - 1}
+		free := true
+		for _, c := range claimed {
+			if c.PortRange != nil && portRange.overlaps(*c.PortRange) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return portRange, nil
+		}
+	}
+	return PortRange{}, errors.New("all the port ranges available for environments are claimed, destroy unused environments")
+}
+
+// NewPortPool creates new pool allocating blocks of ports from the range.
+// Ports allocated to apps are recorded in spec, so app gets the same ports every time it is deployed.
+func NewPortPool(portRange PortRange, spec *Spec) *PortPool {
+	return &PortPool{
+		portRange: portRange,
+		spec:      spec,
+	}
+}
+
+// PortPool allocates blocks of ports to apps
+type PortPool struct {
+	portRange PortRange
+	spec      *Spec
+
+	mu sync.Mutex
+}
+
+// Allocate returns ports allocated to the app, names are the same as in the map of default ports passed.
+// Ports recorded in spec are reused if they belong to the range, otherwise the first block of free ports is allocated.
+// Ports are assigned in the block in alphabetical order of their names.
+func (p *PortPool) Allocate(name string, ports map[string]int) (map[string]int, error) {
+	if len(ports) == 0 {
+		return ports, nil
+	}
+	if len(ports) > portBlockSize {
+		return nil, fmt.Errorf("app %s requires %d ports, at most %d may be allocated", name, len(ports), portBlockSize)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.spec.mu.Lock()
+	defer p.spec.mu.Unlock()
+
+	if allocated, exists := p.spec.Ports[name]; exists && p.covers(allocated, ports) {
+		return copyPorts(allocated), nil
+	}
+
+	used := map[int]bool{}
+	for appName, allocated := range p.spec.Ports {
+		if appName == name {
+			continue
+		}
+		for _, port := range allocated {
+			used[p.block(port)] = true
+		}
+	}
+
+	names := make([]string, 0, len(ports))
+	for portName := range ports {
+		names = append(names, portName)
+	}
+	sort.Strings(names)
+
+	for first := p.portRange.First; first+portBlockSize-1 <= p.portRange.Last; first += portBlockSize {
+		if used[p.block(first)] || !p.free(first, len(names)) {
+			continue
+		}
+		allocated := map[string]int{}
+		for i, portName := range names {
+			allocated[portName] = first + i
+		}
+		if p.spec.Ports == nil {
+			p.spec.Ports = map[string]map[string]int{}
+		}
+		p.spec.Ports[name] = allocated
+		return copyPorts(allocated), nil
+	}
+	return nil, fmt.Errorf("no more ports available in range %s", p.portRange)
+}
+
+// covers returns true if all the ports are allocated and belong to the range
+func (p *PortPool) covers(allocated, ports map[string]int) bool {
+	for portName := range ports {
+		port, exists := allocated[portName]
+		if !exists || port < p.portRange.First || port > p.portRange.Last {
+			return false
+		}
+	}
+	return true
+}
+
+// block returns index of the block port belongs to
+func (p *PortPool) block(port int) int {
+	return (port - p.portRange.First) / portBlockSize
+}
+
+// free returns true if none of the ports starting from the first one is used by other process
+func (p *PortPool) free(first, count int) bool {
+	for port := first; port < first+count; port++ {
+		l, err := net.Listen("tcp", JoinHostPort(p.portRange.IP, port))
+		if err != nil {
+			return false
+		}
+		_ = l.Close()
+	}
+	return true
+}
+
+func copyPorts(ports map[string]int) map[string]int {
+	res := make(map[string]int, len(ports))
+	for name, port := range ports {
+		res[name] = port
+	}
+	return res
+}
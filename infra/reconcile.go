@@ -0,0 +1,66 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// AppStatus is the state of app
+type AppStatus string
+
+const (
+	// AppStatusRunning means app is running
+	AppStatusRunning AppStatus = "running"
+
+	// AppStatusStopped means app is not running but it exists in the target and may be started again
+	AppStatusStopped AppStatus = "stopped"
+
+	// AppStatusMissing means app doesn't exist in the target and has to be deployed again
+	AppStatusMissing AppStatus = "missing"
+)
+
+// Drift describes app whose state recorded in spec differs from the real one
+type Drift struct {
+	// App is the name of app
+	App string
+
+	// Recorded is the status recorded in spec
+	Recorded AppStatus
+
+	// Actual is the status reported by target
+	Actual AppStatus
+}
+
+// String returns description of drift
+func (d Drift) String() string {
+	return fmt.Sprintf("%s: recorded as %s, actually %s", d.App, d.Recorded, d.Actual)
+}
+
+// Reconcile compares status of apps recorded in spec with the one reported by target and updates spec accordingly.
+// Apps which died are marked as stopped, so they are started again instead of being skipped on next deployment.
+func Reconcile(ctx context.Context, target Target, spec *Spec) ([]Drift, error) {
+	names := make([]string, 0, len(spec.Apps))
+	for name := range spec.Apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var drifts []Drift
+	for _, name := range names {
+		appDesc := spec.Apps[name]
+		actual, err := target.AppStatus(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		recorded := appDesc.Status()
+		if recorded == actual {
+			continue
+		}
+
+		drifts = append(drifts, Drift{App: name, Recorded: recorded, Actual: actual})
+		appDesc.Running = actual == AppStatusRunning
+		appDesc.Stopped = actual == AppStatusStopped
+	}
+	return drifts, nil
+}
@@ -0,0 +1,337 @@
+package targets
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ridge/parallel"
+	"github.com/wojciech-sif/localnet/infra"
+)
+
+const (
+	// cgroupPeriod is the period used to limit CPU time, in microseconds
+	cgroupPeriod = 100000
+
+	// cgroupKillTimeout is the time processes have to exit gracefully before they are killed
+	cgroupKillTimeout = 20 * time.Second
+
+	// accessWrite is the mode passed to access syscall to check if file is writable
+	accessWrite = 0x2
+)
+
+// cgroupRoot returns path to cgroup v2 created for apps of the environment.
+// Empty string is returned if cgroup v2 is not available.
+func cgroupRoot(config infra.Config) (string, error) {
+	root, err := cgroupRootPath(config)
+	if err != nil || root == "" {
+		return "", err
+	}
+
+	// Controllers are enabled on the best-effort basis, it is checked later if they are available when limits are set
+	enableControllers := func(cgroup string) {
+		for _, controller := range []string{"cpu", "memory"} {
+			_ = ioutil.WriteFile(filepath.Join(cgroup, "cgroup.subtree_control"), []byte("+"+controller), 0o644)
+		}
+	}
+
+	// Parent exists already unless it is the localnet subtree of the root cgroup
+	for _, cgroup := range []string{filepath.Dir(root), root} {
+		if err := os.Mkdir(cgroup, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+		enableControllers(cgroup)
+	}
+	return root, nil
+}
+
+// cgroupRootPath returns path to cgroup v2 of the environment, it is not created.
+// It is placed in the highest cgroup above the current one which is writable by the user,
+// so it is the one delegated to user by systemd if localnet runs as a regular user. Root cgroup is never modified,
+// if localnet runs as root, environments are created in localnet subtree of it, using controllers enabled there already.
+// Name contains hash of home directory, so environments of the same name living in different home directories don't share it.
+// Empty string is returned if cgroup v2 is not available.
+func cgroupRootPath(config infra.Config) (string, error) {
+	mountPoint, err := cgroup2MountPoint()
+	if err != nil || mountPoint == "" {
+		return "", err
+	}
+	current, err := currentCGroup()
+	if err != nil || current == "" {
+		return "", err
+	}
+
+	var base string
+	for dir := filepath.Join(mountPoint, current); strings.HasPrefix(dir, mountPoint); dir = filepath.Dir(dir) {
+		if syscall.Access(dir, accessWrite) == nil && syscall.Access(filepath.Join(dir, "cgroup.subtree_control"), accessWrite) == nil {
+			base = dir
+		}
+		if dir == mountPoint {
+			break
+		}
+	}
+	if base == "" {
+		return "", nil
+	}
+	if base == mountPoint {
+		base = filepath.Join(mountPoint, "localnet")
+	}
+
+	homeHash := sha256.Sum256([]byte(config.HomeDir))
+	return filepath.Join(base, fmt.Sprintf("localnet-%s-%x", config.EnvName, homeHash[:4])), nil
+}
+
+// removeEnvCGroups terminates processes left in cgroups of apps and removes them together with the cgroup of the environment.
+// They are found by path, because apps are not described in spec once environment is stopped.
+func removeEnvCGroups(ctx context.Context, config infra.Config) error {
+	root, err := cgroupRootPath(config)
+	if err != nil || root == "" {
+		return err
+	}
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var cgroups []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			cgroups = append(cgroups, filepath.Join(root, entry.Name()))
+		}
+	}
+	if err := killCGroups(ctx, cgroups); err != nil {
+		return err
+	}
+	for _, cgroup := range cgroups {
+		if err := os.Remove(cgroup); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Remove(root); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// cgroup2MountPoint returns the mount point of cgroup v2 hierarchy, empty string is returned if it is not mounted
+func cgroup2MountPoint() (string, error) {
+	mountInfo, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer mountInfo.Close()
+
+	scanner := bufio.NewScanner(mountInfo)
+	for scanner.Scan() {
+		// Filesystem type follows the separator
+		fields := strings.Fields(scanner.Text())
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) && fields[i+1] == "cgroup2" {
+				return fields[4], nil
+			}
+		}
+	}
+	return "", scanner.Err()
+}
+
+// currentCGroup returns cgroup v2 of the current process
+func currentCGroup() (string, error) {
+	cgroups, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(cgroups), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+	return "", nil
+}
+
+// createAppCGroup creates cgroup for the app and applies resource limits to it.
+// Empty string is returned if cgroup v2 is not available and no limits are set.
+func createAppCGroup(config infra.Config, appName string, resources infra.Resources) (string, error) {
+	root, err := cgroupRoot(config)
+	if err != nil {
+		return "", err
+	}
+	if root == "" {
+		if resources.Limited() {
+			return "", fmt.Errorf("resource limits of app %s can't be applied because cgroup v2 is not available", appName)
+		}
+		return "", nil
+	}
+
+	cgroup := filepath.Join(root, appName)
+	if err := os.Mkdir(cgroup, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return "", err
+	}
+
+	cpuMax := "max"
+	if resources.CPUs > 0 {
+		cpuMax = strconv.Itoa(int(resources.CPUs * cgroupPeriod))
+	}
+	memoryMax := "max"
+	if resources.Memory > 0 {
+		memoryMax = strconv.FormatUint(resources.Memory, 10)
+	}
+	for file, value := range map[string]string{
+		"cpu.max":    cpuMax + " " + strconv.Itoa(cgroupPeriod),
+		"memory.max": memoryMax,
+	} {
+		// Files of controllers exist only if they are enabled
+		if _, err := os.Stat(filepath.Join(cgroup, file)); err != nil {
+			switch {
+			case errors.Is(err, os.ErrNotExist) && !resources.Limited():
+				continue
+			case errors.Is(err, os.ErrNotExist):
+				return "", fmt.Errorf("resource limits of app %s can't be applied because controllers are not enabled in cgroup %s", appName, root)
+			default:
+				return "", err
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(cgroup, file), []byte(value), 0o644); err != nil {
+			return "", err
+		}
+	}
+	if resources.Memory > 0 {
+		// Swap is not available to the app, so it really runs with the memory it is limited to
+		swapMax := filepath.Join(cgroup, "memory.swap.max")
+		if _, err := os.Stat(swapMax); err == nil {
+			if err := ioutil.WriteFile(swapMax, []byte("0"), 0o644); err != nil {
+				return "", err
+			}
+		}
+	}
+	return cgroup, nil
+}
+
+// cgroupCommand returns bash script moving shell to the cgroup before it executes the app.
+// Shell exits if it can't be moved, otherwise app would run without limits and it wouldn't be found when stopped.
+// It happens if shell is started by process living in cgroup not writable by the user, like tmux server.
+func cgroupCommand(cgroup string) string {
+	if cgroup == "" {
+		return ""
+	}
+	return fmt.Sprintf(`echo $$ > "%[1]s/cgroup.procs" || { echo "can't join cgroup %[1]s"; exit 1; }; `, cgroup)
+}
+
+// cgroupPIDs returns IDs of processes belonging to cgroup
+func cgroupPIDs(cgroup string) ([]int, error) {
+	procs, err := ioutil.ReadFile(filepath.Join(cgroup, "cgroup.procs"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pids []int
+	for _, pidStr := range strings.Fields(string(procs)) {
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil {
+			return nil, err
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// killCGroup terminates all the processes belonging to cgroup, including the ones which started new session or process group.
+// Processes are terminated gracefully, after timeout they are killed.
+func killCGroup(ctx context.Context, cgroup string) error {
+	if cgroup == "" {
+		return nil
+	}
+	if err := signalCGroup(cgroup, syscall.SIGTERM); err != nil {
+		return err
+	}
+	empty, err := waitCGroup(ctx, cgroup, cgroupKillTimeout)
+	if err != nil || empty {
+		return err
+	}
+
+	// cgroup.kill is available since kernel 5.14, it kills processes spawned in the meantime too
+	if err := ioutil.WriteFile(filepath.Join(cgroup, "cgroup.kill"), []byte("1"), 0o644); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := signalCGroup(cgroup, syscall.SIGKILL); err != nil {
+			return err
+		}
+	}
+	empty, err = waitCGroup(ctx, cgroup, cgroupKillTimeout)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return fmt.Errorf("processes in cgroup %s can't be killed", cgroup)
+	}
+	return nil
+}
+
+// killCGroups terminates processes belonging to cgroups concurrently
+func killCGroups(ctx context.Context, cgroups []string) error {
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		for _, cgroup := range cgroups {
+			cgroup := cgroup
+			spawn(cgroup, parallel.Continue, func(ctx context.Context) error {
+				return killCGroup(ctx, cgroup)
+			})
+		}
+		return nil
+	})
+}
+
+// removeCGroup removes cgroup of the app and the cgroup of the environment if it is empty
+func removeCGroup(cgroup string) error {
+	if cgroup == "" {
+		return nil
+	}
+	if err := os.Remove(cgroup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// Other apps may still be there
+	_ = os.Remove(filepath.Dir(cgroup))
+	return nil
+}
+
+func signalCGroup(cgroup string, sig syscall.Signal) error {
+	pids, err := cgroupPIDs(cgroup)
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+	}
+	return nil
+}
+
+func waitCGroup(ctx context.Context, cgroup string, timeout time.Duration) (bool, error) {
+	deadline := time.After(timeout)
+	for {
+		pids, err := cgroupPIDs(cgroup)
+		if err != nil || len(pids) == 0 {
+			return err == nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-deadline:
+			return false, nil
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
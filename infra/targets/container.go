@@ -0,0 +1,135 @@
+package targets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/wojciech-sif/localnet/exec"
+	"github.com/wojciech-sif/localnet/infra"
+	"github.com/wojciech-sif/localnet/lib/logger"
+	"go.uber.org/zap"
+)
+
+// ensureImage pulls image of the app if it doesn't exist locally and returns its reference.
+// If registry is configured, image is taken from there first, so images may be cached locally for offline use.
+func ensureImage(ctx context.Context, registry string, app infra.Container) (string, error) {
+	image := imageReference(app)
+
+	inspectCmd := exec.Docker("image", "inspect", image)
+	inspectCmd.Stdout = ioutil.Discard
+	inspectCmd.Stderr = ioutil.Discard
+	err := exec.Run(ctx, inspectCmd)
+	if err == nil {
+		return image, nil
+	}
+	if errors.Is(err, ctx.Err()) {
+		return "", err
+	}
+
+	if registry != "" {
+		cachedImage := registry + "/" + image
+		err := exec.Run(ctx, exec.Docker("pull", cachedImage), exec.Docker("tag", cachedImage, image))
+		if err == nil {
+			return image, nil
+		}
+		if errors.Is(err, ctx.Err()) {
+			return "", err
+		}
+		logger.Get(ctx).Warn("Pulling image from registry failed, trying the original one",
+			zap.String("registry", registry), zap.String("image", image), zap.Error(err))
+	}
+	if err := exec.Run(ctx, exec.Docker("pull", image)); err != nil {
+		return "", err
+	}
+	return image, nil
+}
+
+// imageReference returns reference to the image of the app
+func imageReference(app infra.Container) string {
+	tag := app.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	return app.Image + ":" + tag
+}
+
+// containerCommand returns command running container in the foreground.
+// Container uses host network, so app listens on the IP allocated by target, and it is removed once stopped.
+// Files and directories required by app are mounted at the same paths they exist on host.
+func containerCommand(config infra.Config, app infra.Container, image string) []string {
+	args := []string{"run", "--rm", "--name", containerName(config, app.Name), "--label", labelEnv + "=" + config.EnvName, "--network", "host"}
+	for _, path := range app.Copy {
+		args = append(args, "-v", path+":"+path)
+	}
+	// Container is run by docker daemon, not by the process started in cgroup, so limits are passed to docker
+	if app.Resources.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(app.Resources.CPUs, 'f', -1, 64))
+	}
+	if app.Resources.Memory > 0 {
+		memory := strconv.FormatUint(app.Resources.Memory, 10)
+		args = append(args, "--memory", memory, "--memory-swap", memory)
+	}
+	cmd := exec.Docker(append(append(args, image), app.Args...)...)
+	return append([]string{cmd.Path}, cmd.Args[1:]...)
+}
+
+// containerName returns name of the container running app in the foreground
+func containerName(config infra.Config, appName string) string {
+	return config.EnvName + "-" + appName
+}
+
+// removeContainers removes containers of apps left behind by processes running them in the foreground.
+// Docker removes container run with --rm only if it exits, not if docker client is killed, and the next start
+// of the app would fail because of name conflict.
+func removeContainers(ctx context.Context, config infra.Config, appNames ...string) error {
+	if len(appNames) == 0 {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	listCmd := exec.Docker(containersFilterArgs(config, appNames)...)
+	listCmd.Stdout = buf
+	if err := exec.Run(ctx, listCmd); err != nil {
+		return err
+	}
+	containerIDs := strings.Fields(buf.String())
+	if len(containerIDs) == 0 {
+		return nil
+	}
+	rmCmd := exec.Docker(append([]string{"rm", "-f"}, containerIDs...)...)
+	rmCmd.Stdout = ioutil.Discard
+	return exec.Run(ctx, rmCmd)
+}
+
+// removeContainersScript returns script removing containers of apps left behind, it is used on remote hosts
+func removeContainersScript(config infra.Config, appNames ...string) string {
+	if len(appNames) == 0 {
+		return ""
+	}
+	return shellCommand(append([]string{"docker"}, containersFilterArgs(config, appNames)...)...) + " | xargs -r docker rm -f > /dev/null\n"
+}
+
+// containersFilterArgs returns arguments of docker command listing IDs of containers running apps in the foreground
+func containersFilterArgs(config infra.Config, appNames []string) []string {
+	args := []string{"ps", "-aq", "--filter", "label=" + labelEnv + "=" + config.EnvName}
+	for _, name := range appNames {
+		// Docker prefixes names with slash, podman doesn't
+		args = append(args, "--filter", "name=^/?"+regexp.QuoteMeta(containerName(config, name))+"$")
+	}
+	return args
+}
+
+// containerApps returns names of apps run in containers in the foreground
+func containerApps(spec *infra.Spec) []string {
+	var names []string
+	for name, appDesc := range spec.Apps {
+		if appDesc.Runtime.Image != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -4,16 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net"
-	"os"
 	osexec "os/exec"
-	"regexp"
-	"strconv"
-	"strings"
+	"sync"
 	"syscall"
 
-	"github.com/ridge/must"
 	"github.com/wojciech-sif/localnet/exec"
 	"github.com/wojciech-sif/localnet/infra"
 )
@@ -23,68 +18,70 @@ func NewDirect(config infra.Config, spec *infra.Spec) infra.Target {
 	return &Direct{
 		config: config,
 		spec:   spec,
-		ipPool: infra.NewIPPool(config.Network),
 	}
 }
 
 // Direct is the target deploying apps to os processes
 type Direct struct {
-	config infra.Config
-	spec   *infra.Spec
-	ipPool *infra.IPPool
+	config      infra.Config
+	spec        *infra.Spec
+	addressPool *infra.AddressPool
+
+	mu sync.Mutex // to protect process group
 }
 
 // Deploy deploys environment to os processes
 func (d *Direct) Deploy(ctx context.Context, env infra.Set) error {
+	addressPool, err := infra.ClaimAddresses(ctx, d.config, d.spec)
+	if err != nil {
+		return err
+	}
+	d.addressPool = addressPool
 	return env.Deploy(ctx, d, d.spec)
 }
 
 // Stop stops running applications
 func (d *Direct) Stop(ctx context.Context) error {
-	if d.spec.PGID == 0 {
-		return nil
-	}
-	procs, err := ioutil.ReadDir("/proc")
-	if err != nil {
-		return err
-	}
-	reg := regexp.MustCompile("^[0-9]+$")
 	pids := []int{}
-	for _, procH := range procs {
-		if !procH.IsDir() || !reg.MatchString(procH.Name()) {
+	cgroups := []string{}
+	for name, appDesc := range d.spec.Apps {
+		if err := refreshSupervisorState(d.config, name, appDesc); err != nil {
+			return err
+		}
+		if appDesc.Runtime.CGroup != "" {
+			cgroups = append(cgroups, appDesc.Runtime.CGroup)
 			continue
 		}
-		statPath := "/proc/" + procH.Name() + "/stat"
-		statRaw, err := ioutil.ReadFile(statPath)
+		running, err := processRunning(appDesc.Runtime, d.spec.PGID)
 		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				continue
-			}
 			return err
 		}
-		properties := strings.Split(string(statRaw), " ")
-		pgID, err := strconv.ParseInt(properties[4], 10, 32)
-		if err != nil {
-			return err
+		if running {
+			pids = append(pids, appDesc.Runtime.PID)
 		}
-		if pgID != int64(d.spec.PGID) {
-			continue
-		}
-		pID := int(must.Int64(strconv.ParseInt(procH.Name(), 10, 32)))
-		if pID == os.Getpid() {
-			continue
+	}
+	if err := killCGroups(ctx, cgroups); err != nil {
+		return err
+	}
+	for _, cgroup := range cgroups {
+		if err := removeCGroup(cgroup); err != nil {
+			return err
 		}
-		pids = append(pids, pID)
 	}
-	if len(pids) == 0 {
-		return nil
+	if len(pids) > 0 {
+		if err := exec.Kill(ctx, pids); err != nil {
+			return err
+		}
 	}
-	return exec.Kill(ctx, pids)
+	return removeContainers(ctx, d.config, containerApps(d.spec)...)
 }
 
 // Destroy destroys running applications
 func (d *Direct) Destroy(ctx context.Context) error {
-	return d.Stop(ctx)
+	if err := d.Stop(ctx); err != nil {
+		return err
+	}
+	return removeEnvCGroups(ctx, d.config)
 }
 
 // DeployBinary starts binary file inside os process
@@ -92,7 +89,7 @@ func (d *Direct) DeployBinary(ctx context.Context, app infra.Binary) error {
 	var ip net.IP
 	if app.RequiresIP {
 		var err error
-		ip, err = d.ipPool.Next()
+		ip, app.Ports, err = d.addressPool.Allocate(app.Name, app.Ports)
 		if err != nil {
 			return err
 		}
@@ -101,19 +98,168 @@ func (d *Direct) DeployBinary(ctx context.Context, app infra.Binary) error {
 	if err := infra.PreprocessApp(ctx, ip, d.config.AppDir, app.AppBase); err != nil {
 		return err
 	}
-	cmd := osexec.Command("bash", "-ce", fmt.Sprintf(`exec %s >> "%s/%s.log" 2>&1`, osexec.Command(app.Path, app.Args...).String(), d.config.LogDir, app.Name))
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-		Pgid:    d.spec.PGID,
+	appDesc, err := d.spec.App(app.Name)
+	if err != nil {
+		return err
 	}
+	appDesc.Command = append([]string{app.Path}, app.Args...)
+	appDesc.Resources = app.Resources
+	if err := d.startProcess(app.Name, appDesc); err != nil {
+		return err
+	}
+	return infra.PostprocessApp(ctx, infra.Deployment{IP: ip, Runtime: appDesc.Runtime}, app.AppBase)
+}
+
+// StopApp stops process running the app
+func (d *Direct) StopApp(ctx context.Context, name string) error {
+	appDesc, err := d.spec.App(name)
+	if err != nil {
+		return err
+	}
+	if err := d.stopProcess(ctx, appDesc); err != nil {
+		return err
+	}
+	if appDesc.Runtime.Image != "" {
+		if err := removeContainers(ctx, d.config, name); err != nil {
+			return err
+		}
+	}
+	appDesc.Runtime.PID = 0
+	appDesc.Runtime.StartTime = 0
+	return nil
+}
 
-	if err := cmd.Start(); err != nil {
+// StartApp starts new process running the app
+func (d *Direct) StartApp(ctx context.Context, name string) error {
+	appDesc, err := d.spec.App(name)
+	if err != nil {
 		return err
 	}
-	return infra.PostprocessApp(ctx, ip, app.AppBase)
+	if len(appDesc.Command) == 0 {
+		return fmt.Errorf("command used to run app %s is not recorded in spec", name)
+	}
+	if appDesc.Runtime.Image != "" {
+		// Container left behind by app killed previously would conflict with the new one
+		if err := removeContainers(ctx, d.config, name); err != nil {
+			return err
+		}
+	}
+	return d.startProcess(name, appDesc)
 }
 
-// DeployContainer starts container
+// AppStatus returns status of app based on existence of its process
+func (d *Direct) AppStatus(ctx context.Context, name string) (infra.AppStatus, error) {
+	appDesc, err := d.spec.App(name)
+	if err != nil {
+		return "", err
+	}
+	if err := refreshSupervisorState(d.config, name, appDesc); err != nil {
+		return "", err
+	}
+	running, err := processRunning(appDesc.Runtime, d.spec.PGID)
+	if err != nil {
+		return "", err
+	}
+	if running {
+		return infra.AppStatusRunning, nil
+	}
+	if len(appDesc.Command) == 0 {
+		return infra.AppStatusMissing, nil
+	}
+	return infra.AppStatusStopped, nil
+}
+
+func (d *Direct) stopProcess(ctx context.Context, appDesc *infra.AppDescription) error {
+	if appDesc.Runtime.CGroup != "" {
+		if err := killCGroup(ctx, appDesc.Runtime.CGroup); err != nil {
+			return err
+		}
+		return removeCGroup(appDesc.Runtime.CGroup)
+	}
+	running, err := processRunning(appDesc.Runtime, d.spec.PGID)
+	if err != nil || !running {
+		return err
+	}
+	return exec.Kill(ctx, []int{appDesc.Runtime.PID})
+}
+
+func (d *Direct) startProcess(name string, appDesc *infra.AppDescription) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	command := appDesc.Command
+	supervised := d.config.Supervise || appDesc.Runtime.Supervised
+	if supervised {
+		var err error
+		command, err = supervisedCommand(d.config, name, command)
+		if err != nil {
+			return err
+		}
+	}
+
+	cgroup, err := createAppCGroup(d.config, name, appDesc.Resources)
+	if err != nil {
+		return err
+	}
+
+	logPath := d.config.LogDir + "/" + name + ".log"
+	newCmd := func(pgID int) *osexec.Cmd {
+		cmd := osexec.Command("bash", "-ce", fmt.Sprintf(`%sexec %s >> "%s" 2>&1`, cgroupCommand(cgroup), osexec.Command(command[0], command[1:]...).String(), logPath))
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setpgid: true,
+			Pgid:    pgID,
+		}
+		return cmd
+	}
+
+	cmd := newCmd(d.spec.PGID)
+	err = cmd.Start()
+	if errors.Is(err, syscall.EPERM) {
+		// Process group doesn't exist anymore because all the apps have been stopped, so new one is created
+		cmd = newCmd(0)
+		err = cmd.Start()
+		if err == nil {
+			d.spec.PGID = cmd.Process.Pid
+		}
+	}
+	if err != nil {
+		return err
+	}
+	image := appDesc.Runtime.Image
+	appDesc.Runtime, err = processRuntime(cmd.Process.Pid, logPath, appDesc.Command[0])
+	appDesc.Runtime.Image = image
+	appDesc.Runtime.Supervised = supervised
+	appDesc.Runtime.CGroup = cgroup
+	return err
+}
+
+// DeployContainer starts container in the foreground inside os process
 func (d *Direct) DeployContainer(ctx context.Context, app infra.Container) error {
-	panic("not implemented yet")
+	image, err := ensureImage(ctx, d.config.Registry, app)
+	if err != nil {
+		return err
+	}
+	ip, ports, err := d.addressPool.Allocate(app.Name, app.Ports)
+	if err != nil {
+		return err
+	}
+	app.Ports = ports
+
+	if err := infra.PreprocessApp(ctx, ip, d.config.AppDir, app.AppBase); err != nil {
+		return err
+	}
+	appDesc, err := d.spec.App(app.Name)
+	if err != nil {
+		return err
+	}
+	if err := removeContainers(ctx, d.config, app.Name); err != nil {
+		return err
+	}
+	appDesc.Command = containerCommand(d.config, app, image)
+	appDesc.Resources = app.Resources
+	appDesc.Runtime.Image = image
+	if err := d.startProcess(app.Name, appDesc); err != nil {
+		return err
+	}
+	return infra.PostprocessApp(ctx, infra.Deployment{IP: ip, Runtime: appDesc.Runtime}, app.AppBase)
 }
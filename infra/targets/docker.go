@@ -3,23 +3,36 @@ package targets
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
 	osexec "os/exec"
+	"path/filepath"
 	"strings"
 	"text/template"
+	"time"
 
+	"github.com/ridge/parallel"
 	"github.com/wojciech-sif/localnet/exec"
 	"github.com/wojciech-sif/localnet/infra"
+	"github.com/wojciech-sif/localnet/lib/logger"
+	"go.uber.org/zap"
 )
 
-const labelEnv = "finance.sifchain.localnet.env"
+const (
+	labelEnv  = "finance.sifchain.localnet.env"
+	labelHash = "finance.sifchain.localnet.hash"
+)
 
-const dockerTplContent = `FROM fedora:latest
+const dockerTplContent = `FROM {{ .From }}
 {{ range .Copy }}
 COPY {{ . }} {{ . }}
 {{ end }}
-ENTRYPOINT ["{{ .Path }}"]
+{{ if .Entrypoint }}ENTRYPOINT ["bash", "-c", "exec \"$0\" \"$@\" > >(tee -a \"{{ .LogPath }}\") 2>&1", "{{ .Entrypoint }}"]{{ end }}
 `
 
 var dockerTpl = template.Must(template.New("").Parse(dockerTplContent))
@@ -32,10 +45,19 @@ func NewDocker(config infra.Config, spec *infra.Spec) infra.Target {
 	}
 }
 
+// newDockerIPPool creates IP pool allocating IPs from /24 network created for the environment
+func newDockerIPPool(network *net.IPNet, spec *infra.Spec) *infra.IPPool {
+	ipPool := infra.NewIPPool(network, spec)
+	// The first IP in the network is taken by the gateway
+	ipPool.Reserve(nextIP(network.IP))
+	return ipPool
+}
+
 // Docker is the target deploying apps to docker
 type Docker struct {
 	config infra.Config
 	spec   *infra.Spec
+	ipPool *infra.IPPool
 }
 
 // Stop stops running applications
@@ -47,16 +69,15 @@ func (d *Docker) Stop(ctx context.Context) error {
 		return err
 	}
 
-	commands := []*osexec.Cmd{}
+	commands := [][]*osexec.Cmd{}
 	for _, cID := range strings.Split(buf.String(), "\n") {
 		// last item is empty
 		if cID == "" {
 			break
 		}
-		commands = append(commands, exec.Docker("stop", "--time", "60", cID))
+		commands = append(commands, []*osexec.Cmd{exec.Docker("stop", "--time", "60", cID)})
 	}
-	// FIXME (wojciech): parallelize this
-	return exec.Run(ctx, commands...)
+	return runConcurrently(ctx, commands...)
 }
 
 // Destroy destroys running applications
@@ -64,54 +85,425 @@ func (d *Docker) Destroy(ctx context.Context) error {
 	if err := d.dropContainers(ctx); err != nil {
 		return err
 	}
+	if err := d.dropNetwork(ctx); err != nil {
+		return err
+	}
 	return d.dropImages(ctx)
 }
 
 // Deploy deploys environment to docker target
 func (d *Docker) Deploy(ctx context.Context, env infra.Set) error {
+	if d.config.Allocation == infra.AllocationPorts {
+		return fmt.Errorf("allocation mode %s is not supported by docker target", infra.AllocationPorts)
+	}
+	network, err := d.claimNetwork(ctx)
+	if err != nil {
+		return err
+	}
+	d.ipPool = newDockerIPPool(network, d.spec)
+	if err := d.ensureNetwork(ctx, network); err != nil {
+		return err
+	}
+	// Containers existing already are resumed, so their IPs can't be assigned to other apps
+	containers, err := d.containers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		info, err := d.inspectContainer(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+		if info.IP != nil {
+			d.ipPool.Reserve(info.IP)
+		}
+	}
 	return env.Deploy(ctx, d, d.spec)
 }
 
 // DeployBinary builds container image out of binary file and starts it in docker
 func (d *Docker) DeployBinary(ctx context.Context, app infra.Binary) error {
-	if err := infra.PreprocessApp(ctx, net.IPv4zero, d.config.AppDir, app.AppBase); err != nil {
+	return d.deploy(ctx, app.AppBase, "fedora:latest", app.Path, infra.Runtime{BinaryPath: app.Path})
+}
+
+// DeployContainer starts container in docker, image is pulled if it doesn't exist locally
+func (d *Docker) DeployContainer(ctx context.Context, app infra.Container) error {
+	image, err := ensureImage(ctx, d.config.Registry, app)
+	if err != nil {
+		return err
+	}
+	return d.deploy(ctx, app.AppBase, image, "", infra.Runtime{Image: image})
+}
+
+// deploy builds image containing files required by app on top of the base one and starts container out of it.
+// If container exists already, because environment has been stopped, it is started again instead
+// and image is rebuilt only if binary or files copied to it have changed.
+func (d *Docker) deploy(ctx context.Context, app infra.AppBase, baseImage, entrypoint string, runtime infra.Runtime) error {
+	// App and log directories are mounted, so only files stored somewhere else are copied to the image
+	var copyPaths []string
+	for _, path := range app.Copy {
+		if !isSubPath(d.config.AppDir, path) && !isSubPath(d.config.LogDir, path) {
+			copyPaths = append(copyPaths, path)
+		}
+	}
+	// Hash is computed before args are preprocessed, because preprocessing modifies them
+	hash, err := buildHash(baseImage, entrypoint, copyPaths, app.Args)
+	if err != nil {
+		return err
+	}
+
+	name := d.containerName(app.Name)
+	existing, err := d.existingContainer(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	var ip net.IP
+	if existing != nil {
+		// State of the app is kept in mounted app directory, so it is not prepared again
+		ip = existing.IP
+		if err := infra.ResumeApp(ctx, ip, app); err != nil {
+			return err
+		}
+	} else {
+		// IP is assigned to every container, so it is known before app is preprocessed and it doesn't change on restart
+		ip, err = d.ipPool.Allocate(app.Name)
+		if err != nil {
+			return err
+		}
+		if err := infra.PreprocessApp(ctx, ip, d.config.AppDir, app); err != nil {
+			return err
+		}
+	}
+
+	since := time.Now().UTC().Format(time.RFC3339Nano)
+	var containerID string
+	switch {
+	case existing != nil && existing.Hash == hash:
+		containerID = existing.ID
+		if !existing.Running {
+			if err := exec.Run(ctx, exec.Docker("start", containerID)); err != nil {
+				return err
+			}
+		}
+	default:
+		if existing != nil {
+			if err := exec.Run(ctx, exec.Docker("rm", "-f", existing.ID)); err != nil {
+				return err
+			}
+		}
+		image := d.config.EnvName + "/" + app.Name + ":latest"
+		if err := d.buildImage(ctx, image, hash, baseImage, entrypoint, copyPaths, d.logPath(app.Name)); err != nil {
+			return err
+		}
+		containerID, err = d.runContainer(ctx, app, image, hash, entrypoint, ip)
+		if err != nil {
+			return err
+		}
+		since = ""
+	}
+
+	appDesc, err := d.spec.App(app.Name)
+	if err != nil {
+		return err
+	}
+	runtime.ContainerID = containerID
+	runtime.LogPath = d.logPath(app.Name)
+	appDesc.Runtime = runtime
+	if entrypoint == "" {
+		// Output of prebuilt image can't be redirected to log file inside the container
+		if err := d.followLogs(app.Name, since); err != nil {
+			return err
+		}
+	}
+	return infra.PostprocessApp(ctx, infra.Deployment{IP: ip, Runtime: appDesc.Runtime}, app)
+}
+
+// buildImage builds image of the app, build is skipped if image built out of the same content exists already
+func (d *Docker) buildImage(ctx context.Context, image, hash, baseImage, entrypoint string, copyPaths []string, logPath string) error {
+	hashBuf := &bytes.Buffer{}
+	inspectCmd := exec.Docker("image", "inspect", "-f", `{{ index .Config.Labels "`+labelHash+`" }}`, image)
+	inspectCmd.Stdout = hashBuf
+	inspectCmd.Stderr = ioutil.Discard
+	err := exec.Run(ctx, inspectCmd)
+	if err != nil && errors.Is(err, ctx.Err()) {
 		return err
 	}
+	if err == nil && strings.TrimSpace(hashBuf.String()) == hash {
+		return nil
+	}
 
 	buf := &bytes.Buffer{}
-	if err := dockerTpl.Execute(buf, app); err != nil {
+	err = dockerTpl.Execute(buf, struct {
+		From       string
+		Copy       []string
+		Entrypoint string
+		LogPath    string
+	}{
+		From:       baseImage,
+		Copy:       copyPaths,
+		Entrypoint: entrypoint,
+		LogPath:    logPath,
+	})
+	if err != nil {
 		return err
 	}
 
-	image := d.config.EnvName + "/" + app.Name + ":latest"
-	buildCmd := exec.Docker("build", "--tag", image, "--label", labelEnv+"="+d.config.EnvName, "-f-", "/")
+	buildCmd := exec.Docker("build", "--tag", image, "--label", labelEnv+"="+d.config.EnvName, "--label", labelHash+"="+hash, "-f-", "/")
 	buildCmd.Stdin = buf
+	return exec.Run(ctx, buildCmd)
+}
 
-	name := d.config.EnvName + "-" + app.Name
-	ipBuf := &bytes.Buffer{}
-	ipCmd := exec.Docker("inspect", "-f", "{{range.NetworkSettings.Networks}}{{.IPAddress}}{{end}}", name)
-	ipCmd.Stdout = ipBuf
-	err := exec.Run(ctx,
-		buildCmd,
-		exec.Docker(append([]string{"run", "--name", name, "-d", "--label", labelEnv + "=" + d.config.EnvName, image}, app.Args...)...),
-		ipCmd,
-	)
+// runContainer creates and starts container of the app, ID of the container is returned.
+// Entrypoint is empty if app runs prebuilt image.
+func (d *Docker) runContainer(ctx context.Context, app infra.AppBase, image, hash, entrypoint string, ip net.IP) (string, error) {
+	// Apps may reach each other using their names thanks to DNS server embedded in docker
+	runArgs := []string{"run", "--name", d.containerName(app.Name), "-d",
+		"--label", labelEnv + "=" + d.config.EnvName, "--label", labelHash + "=" + hash,
+		"--network", d.networkName(), "--ip", ip.String(), "--network-alias", app.Name, "--hostname", app.Name,
+		"-v", d.config.AppDir + ":" + d.config.AppDir, "-v", d.config.LogDir + ":" + d.config.LogDir,
+	}
+	// Binary runs as host user, so files it creates in mounted directories are accessible from host.
+	// Prebuilt images run as the user they are built for, because they may require it.
+	if entrypoint != "" {
+		if exec.DockerIsPodman() {
+			runArgs = append(runArgs, "--userns", "keep-id")
+		}
+		runArgs = append(runArgs, "--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()))
+	}
+
+	idBuf := &bytes.Buffer{}
+	runCmd := exec.Docker(append(append(runArgs, image), app.Args...)...)
+	runCmd.Stdout = idBuf
+	if err := exec.Run(ctx, runCmd); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(idBuf.String(), "\n"), nil
+}
+
+// containerInfo contains properties of existing container
+type containerInfo struct {
+	ID      string
+	IP      net.IP
+	Running bool
+	Hash    string
+}
+
+// existingContainer returns info about container if it exists, nil is returned otherwise
+func (d *Docker) existingContainer(ctx context.Context, name string) (*containerInfo, error) {
+	containers, err := d.containers(ctx)
 	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers {
+		if c.Name == name {
+			return d.inspectContainer(ctx, c.ID)
+		}
+	}
+	return nil, nil
+}
+
+func (d *Docker) inspectContainer(ctx context.Context, ref string) (*containerInfo, error) {
+	buf := &bytes.Buffer{}
+	inspectCmd := exec.Docker("inspect", "-f",
+		`{{ .Id }} {{ .State.Running }} {{ range .NetworkSettings.Networks }}{{ .IPAddress }}{{ end }} {{ index .Config.Labels "`+labelHash+`" }}`, ref)
+	inspectCmd.Stdout = buf
+	if err := exec.Run(ctx, inspectCmd); err != nil {
+		return nil, err
+	}
+	// IP and hash may be empty, so fields can't be split using any whitespace
+	fields := strings.Split(strings.TrimSuffix(buf.String(), "\n"), " ")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("unexpected output of container inspection: %q", buf.String())
+	}
+	return &containerInfo{
+		ID:      fields[0],
+		Running: fields[1] == "true",
+		IP:      net.ParseIP(fields[2]),
+		Hash:    fields[3],
+	}, nil
+}
+
+// claimNetwork claims /24 network for the environment, so networks of different environments never overlap
+func (d *Docker) claimNetwork(ctx context.Context) (*net.IPNet, error) {
+	config := d.config
+	if d.spec.Network == "" {
+		// Network created by older version of localnet is not recorded in spec, so its subnet is read from docker
+		subnet, err := d.networkSubnet(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if subnet != nil {
+			config.DockerNetwork = subnet.IP
+		}
+	}
+	return infra.ClaimDockerNetwork(ctx, config, d.spec)
+}
+
+// ensureNetwork creates network dedicated to the environment if it doesn't exist yet
+func (d *Docker) ensureNetwork(ctx context.Context, network *net.IPNet) error {
+	exists, err := d.networkExists(ctx)
+	if err != nil || exists {
+		return err
+	}
+	return exec.Run(ctx, exec.Docker("network", "create",
+		"--label", labelEnv+"="+d.config.EnvName,
+		"--subnet", network.String(),
+		"--gateway", nextIP(network.IP).String(),
+		d.networkName()))
+}
+
+// networkSubnet returns subnet of network dedicated to the environment, nil is returned if network doesn't exist
+func (d *Docker) networkSubnet(ctx context.Context) (*net.IPNet, error) {
+	exists, err := d.networkExists(ctx)
+	if err != nil || !exists {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	inspectCmd := exec.Docker("network", "inspect", "-f", "{{ range .IPAM.Config }}{{ .Subnet }} {{ end }}", d.networkName())
+	inspectCmd.Stdout = buf
+	if err := exec.Run(ctx, inspectCmd); err != nil {
+		return nil, err
+	}
+	for _, subnet := range strings.Fields(buf.String()) {
+		if _, network, err := net.ParseCIDR(subnet); err == nil && network.IP.To4() != nil {
+			return network, nil
+		}
+	}
+	return nil, fmt.Errorf("network %s has no IPv4 subnet", d.networkName())
+}
+
+func (d *Docker) dropNetwork(ctx context.Context) error {
+	exists, err := d.networkExists(ctx)
+	if err != nil || !exists {
 		return err
 	}
+	return exec.Run(ctx, exec.Docker("network", "rm", d.networkName()))
+}
 
-	err = osexec.Command("bash", "-ce",
-		fmt.Sprintf("%s >> \"%s/%s.log\" 2>&1", exec.Docker("logs", "-f", name).String(),
-			d.config.LogDir, app.Name)).Start()
+func (d *Docker) networkExists(ctx context.Context) (bool, error) {
+	buf := &bytes.Buffer{}
+	listCmd := exec.Docker("network", "ls", "-q", "--filter", "label="+labelEnv+"="+d.config.EnvName)
+	listCmd.Stdout = buf
+	if err := exec.Run(ctx, listCmd); err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(buf.String()) != "", nil
+}
+
+func (d *Docker) networkName() string {
+	return "localnet-" + d.config.EnvName
+}
+
+// StopApp stops container running the app
+func (d *Docker) StopApp(ctx context.Context, name string) error {
+	appDesc, err := d.spec.App(name)
 	if err != nil {
 		return err
 	}
-	return infra.PostprocessApp(ctx, net.ParseIP(strings.TrimSuffix(ipBuf.String(), "\n")), app.AppBase)
+	return exec.Run(ctx, exec.Docker("stop", "--time", "60", d.container(name, appDesc)))
 }
 
-// DeployContainer starts container in docker
-func (d *Docker) DeployContainer(ctx context.Context, app infra.Container) error {
-	panic("not implemented yet")
+// StartApp starts stopped container running the app
+func (d *Docker) StartApp(ctx context.Context, name string) error {
+	appDesc, err := d.spec.App(name)
+	if err != nil {
+		return err
+	}
+
+	since := time.Now().UTC().Format(time.RFC3339Nano)
+	ipBuf := &bytes.Buffer{}
+	ipCmd := exec.Docker("inspect", "-f", "{{range.NetworkSettings.Networks}}{{.IPAddress}}{{end}}", d.container(name, appDesc))
+	ipCmd.Stdout = ipBuf
+	if err := exec.Run(ctx, exec.Docker("start", d.container(name, appDesc)), ipCmd); err != nil {
+		return err
+	}
+	if ip := net.ParseIP(strings.TrimSuffix(ipBuf.String(), "\n")); appDesc.IP != nil && !ip.Equal(appDesc.IP) {
+		logger.Get(ctx).Warn("Container got different IP than the one stored in spec, endpoints are not valid anymore",
+			zap.String("app", name), zap.Stringer("oldIP", appDesc.IP), zap.Stringer("newIP", ip))
+	}
+	if appDesc.Runtime.BinaryPath != "" {
+		// Binary writes logs to mounted log directory by itself
+		return nil
+	}
+	return d.followLogs(name, since)
+}
+
+// AppStatus returns status of app based on state of its container
+func (d *Docker) AppStatus(ctx context.Context, name string) (infra.AppStatus, error) {
+	appDesc, err := d.spec.App(name)
+	if err != nil {
+		return "", err
+	}
+	containers, err := d.containers(ctx)
+	if err != nil {
+		return "", err
+	}
+	ref := d.container(name, appDesc)
+	for _, c := range containers {
+		if c.ID != ref && c.Name != ref {
+			continue
+		}
+		if c.State == "running" {
+			return infra.AppStatusRunning, nil
+		}
+		return infra.AppStatusStopped, nil
+	}
+	return infra.AppStatusMissing, nil
+}
+
+type container struct {
+	ID    string
+	Name  string
+	State string
+}
+
+// containers returns all the containers existing in the environment
+func (d *Docker) containers(ctx context.Context) ([]container, error) {
+	buf := &bytes.Buffer{}
+	listCmd := exec.Docker("ps", "-a", "--no-trunc", "--filter", "label="+labelEnv+"="+d.config.EnvName, "--format", "{{.ID}} {{.Names}} {{.State}}")
+	listCmd.Stdout = buf
+	if err := exec.Run(ctx, listCmd); err != nil {
+		return nil, err
+	}
+
+	var containers []container
+	for _, line := range strings.Split(buf.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		containers = append(containers, container{ID: fields[0], Name: fields[1], State: fields[2]})
+	}
+	return containers, nil
+}
+
+// container returns reference to the container running the app, name is used if ID hasn't been recorded
+func (d *Docker) container(name string, appDesc *infra.AppDescription) string {
+	if appDesc.Runtime.ContainerID != "" {
+		return appDesc.Runtime.ContainerID
+	}
+	return d.containerName(name)
+}
+
+func (d *Docker) containerName(appName string) string {
+	return d.config.EnvName + "-" + appName
+}
+
+// followLogs copies logs produced by container to the log file, if since is not empty only newer logs are copied
+func (d *Docker) followLogs(appName, since string) error {
+	args := []string{"logs", "-f"}
+	if since != "" {
+		// Logs produced before container was restarted are already in the log file
+		args = append(args, "--since", since)
+	}
+	return osexec.Command("bash", "-ce",
+		fmt.Sprintf("%s >> \"%s\" 2>&1", exec.Docker(append(args, d.containerName(appName))...).String(),
+			d.logPath(appName))).Start()
+}
+
+func (d *Docker) logPath(appName string) string {
+	return d.config.LogDir + "/" + appName + ".log"
 }
 
 func (d *Docker) dropContainers(ctx context.Context) error {
@@ -122,17 +514,18 @@ func (d *Docker) dropContainers(ctx context.Context) error {
 		return err
 	}
 
-	commands := []*osexec.Cmd{}
+	commands := [][]*osexec.Cmd{}
 	for _, cID := range strings.Split(buf.String(), "\n") {
 		// last item is empty
 		if cID == "" {
 			break
 		}
-		commands = append(commands, exec.Docker("stop", "--time", "60", cID))
-		commands = append(commands, exec.Docker("rm", cID))
+		commands = append(commands, []*osexec.Cmd{
+			exec.Docker("stop", "--time", "60", cID),
+			exec.Docker("rm", cID),
+		})
 	}
-	// FIXME (wojciech): parallelize this
-	return exec.Run(ctx, commands...)
+	return runConcurrently(ctx, commands...)
 }
 
 func (d *Docker) dropImages(ctx context.Context) error {
@@ -143,14 +536,64 @@ func (d *Docker) dropImages(ctx context.Context) error {
 		return err
 	}
 
-	commands := []*osexec.Cmd{}
+	commands := [][]*osexec.Cmd{}
 	for _, imageID := range strings.Split(buf.String(), "\n") {
 		// last item is empty
 		if imageID == "" {
 			break
 		}
-		commands = append(commands, exec.Docker("rmi", "-f", imageID))
+		commands = append(commands, []*osexec.Cmd{exec.Docker("rmi", "-f", imageID)})
+	}
+	return runConcurrently(ctx, commands...)
+}
+
+// runConcurrently runs sequences of commands concurrently, commands in each sequence are executed one by one
+func runConcurrently(ctx context.Context, sequences ...[]*osexec.Cmd) error {
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		for i, cmds := range sequences {
+			cmds := cmds
+			spawn(fmt.Sprintf("cmd-%d", i), parallel.Continue, func(ctx context.Context) error {
+				return exec.Run(ctx, cmds...)
+			})
+		}
+		return nil
+	})
+}
+
+// buildHash returns hash of the content used to build image of the app.
+// Files are compared using their size and modification time, so it is not required to read big binaries.
+func buildHash(baseImage, entrypoint string, copyPaths, args []string) (string, error) {
+	hasher := sha256.New()
+	fmt.Fprintln(hasher, baseImage, entrypoint, args)
+	for _, path := range copyPaths {
+		err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(hasher, path, info.Mode(), info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// isSubPath returns true if path is equal to dir or it is located inside it
+func isSubPath(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// nextIP returns IP following the one passed
+func nextIP(ip net.IP) net.IP {
+	if ip == nil {
+		// Network is not configured for commands which don't deploy apps
+		return nil
 	}
-	// FIXME (wojciech): parallelize this
-	return exec.Run(ctx, commands...)
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	next[len(next)-1]++
+	return next
 }
@@ -0,0 +1,88 @@
+package targets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/wojciech-sif/localnet/infra"
+)
+
+// process contains properties of running process read from /proc
+type process struct {
+	// PGID is the ID of process group
+	PGID int
+
+	// StartTime is the start time of process in clock ticks since boot
+	StartTime uint64
+}
+
+// readProcess reads properties of process, false is returned if process doesn't exist or it is a zombie
+func readProcess(pID int) (process, bool, error) {
+	statRaw, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return process{}, false, nil
+		}
+		return process{}, false, err
+	}
+	return parseProcessStat(statRaw)
+}
+
+// parseProcessStat parses content of /proc/<pid>/stat, false is returned if process is a zombie
+func parseProcessStat(statRaw []byte) (process, bool, error) {
+	// Name of the executable is enclosed in parentheses and may contain spaces,
+	// fields are counted from the state which is the third one
+	properties := strings.Fields(string(statRaw[bytes.LastIndexByte(statRaw, ')')+1:]))
+	if properties[0] == "Z" {
+		return process{}, false, nil
+	}
+	pgID, err := strconv.ParseInt(properties[2], 10, 32)
+	if err != nil {
+		return process{}, false, err
+	}
+	startTime, err := strconv.ParseUint(properties[19], 10, 64)
+	if err != nil {
+		return process{}, false, err
+	}
+	return process{PGID: int(pgID), StartTime: startTime}, true, nil
+}
+
+// processRuntime returns runtime handles of just started process
+func processRuntime(pID int, logPath, binaryPath string) (infra.Runtime, error) {
+	proc, exists, err := readProcess(pID)
+	if err != nil {
+		return infra.Runtime{}, err
+	}
+	if !exists {
+		return infra.Runtime{}, fmt.Errorf("process %d exited right after start, check logs in %s", pID, logPath)
+	}
+	return infra.Runtime{
+		PID:        pID,
+		StartTime:  proc.StartTime,
+		LogPath:    logPath,
+		BinaryPath: binaryPath,
+	}, nil
+}
+
+// processRunning returns true if process recorded in runtime handles is still running.
+// Start time is compared because PID might have been reused by unrelated process.
+// If start time hasn't been recorded, process has to belong to process group pgID.
+func processRunning(runtime infra.Runtime, pgID int) (bool, error) {
+	if runtime.PID == 0 {
+		return false, nil
+	}
+	proc, exists, err := readProcess(runtime.PID)
+	if err != nil || !exists {
+		return false, err
+	}
+	if runtime.StartTime == 0 {
+		// Start time is not recorded in specs created by older versions of localnet
+		return pgID != 0 && proc.PGID == pgID, nil
+	}
+	return proc.StartTime == runtime.StartTime, nil
+}
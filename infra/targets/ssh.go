@@ -0,0 +1,487 @@
+package targets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	osexec "os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ridge/parallel"
+	"github.com/wojciech-sif/localnet/exec"
+	"github.com/wojciech-sif/localnet/infra"
+)
+
+// remoteKillTimeout is the time apps running on remote host have to exit gracefully before they are killed
+const remoteKillTimeout = 20 * time.Second
+
+// NewSSH creates new ssh target
+func NewSSH(config infra.Config, spec *infra.Spec) infra.Target {
+	return &SSH{
+		config: config,
+		spec:   spec,
+	}
+}
+
+// SSH is the target deploying apps to processes running on remote host accessed over ssh.
+// Files required by apps are copied to the same paths on remote host and logs are streamed back to the local ones.
+type SSH struct {
+	config infra.Config
+	spec   *infra.Spec
+	ipPool *infra.IPPool
+}
+
+// Deploy deploys environment to remote host
+func (s *SSH) Deploy(ctx context.Context, env infra.Set) error {
+	if s.config.Allocation == infra.AllocationPorts {
+		return fmt.Errorf("allocation mode %s is not supported by ssh target", infra.AllocationPorts)
+	}
+	// Health checks and clients run locally, so apps running on remote host have to listen on IPs reachable from here
+	local := isLocalHost(s.config.SSHHost)
+	if !local && s.config.Network == nil && s.spec.Network == "" {
+		return fmt.Errorf("network assigned to host %s and routable from local machine has to be passed in --network", s.config.SSHHost)
+	}
+	network, err := infra.ClaimNetwork(ctx, s.config, s.spec)
+	if err != nil {
+		return err
+	}
+	if !local && network.IP.IsLoopback() {
+		return fmt.Errorf("network %s is the loopback of host %s, it is not reachable from local machine", network, s.config.SSHHost)
+	}
+	s.ipPool = infra.NewIPPool(network, s.spec)
+	return env.Deploy(ctx, s, s.spec)
+}
+
+// Stop stops running applications
+func (s *SSH) Stop(ctx context.Context) error {
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		// Each host is contacted once to stop all the apps running there
+		for host, apps := range s.hosts() {
+			host := host
+			apps := apps
+			spawn(host, parallel.Continue, func(ctx context.Context) error {
+				return s.stopApps(ctx, host, apps)
+			})
+		}
+		return nil
+	})
+}
+
+// Destroy destroys running applications and removes files copied to remote hosts
+func (s *SSH) Destroy(ctx context.Context) error {
+	if err := s.Stop(ctx); err != nil {
+		return err
+	}
+	hosts := []string{}
+	for host := range s.hosts() {
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		// Spec is reset once environment is stopped, so hosts are not known anymore
+		hosts = append(hosts, s.config.SSHHost)
+	}
+	for host := range s.spec.RemoteFiles {
+		hosts = append(hosts, host)
+	}
+	commands := [][]*osexec.Cmd{}
+	for _, host := range uniqueStrings(hosts) {
+		// Files created outside home dir, like binaries, are removed too
+		paths := append([]string{s.config.HomeDir}, s.spec.RemoteFiles[host]...)
+		commands = append(commands, []*osexec.Cmd{exec.SSH(host, shellCommand(append([]string{"rm", "-rf", "--"}, paths...)...))})
+	}
+	return runConcurrently(ctx, commands...)
+}
+
+// DeployBinary copies binary and files required by it to remote host and starts it there
+func (s *SSH) DeployBinary(ctx context.Context, app infra.Binary) error {
+	var ip net.IP
+	if app.RequiresIP {
+		var err error
+		ip, err = s.ipPool.Allocate(app.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := infra.PreprocessApp(ctx, ip, s.config.AppDir, app.AppBase); err != nil {
+		return err
+	}
+	appDesc, err := s.spec.App(app.Name)
+	if err != nil {
+		return err
+	}
+	if err := s.copyToHost(ctx, s.config.SSHHost, app.Copy); err != nil {
+		return err
+	}
+	appDesc.Command = append([]string{app.Path}, app.Args...)
+	if err := s.startApp(ctx, s.config.SSHHost, app.Name, appDesc); err != nil {
+		return err
+	}
+	return infra.PostprocessApp(ctx, infra.Deployment{IP: ip, Runtime: appDesc.Runtime}, app.AppBase)
+}
+
+// DeployContainer starts container using docker installed on remote host
+func (s *SSH) DeployContainer(ctx context.Context, app infra.Container) error {
+	ip, err := s.ipPool.Allocate(app.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := infra.PreprocessApp(ctx, ip, s.config.AppDir, app.AppBase); err != nil {
+		return err
+	}
+	appDesc, err := s.spec.App(app.Name)
+	if err != nil {
+		return err
+	}
+	if err := s.copyToHost(ctx, s.config.SSHHost, app.Copy); err != nil {
+		return err
+	}
+	image := imageReference(app)
+	appDesc.Command = containerCommand(s.config, app, image)
+	// Image is pulled by docker on remote host, podman used locally might not be installed there
+	appDesc.Command[0] = "docker"
+	appDesc.Runtime.Image = image
+	if err := s.startApp(ctx, s.config.SSHHost, app.Name, appDesc); err != nil {
+		return err
+	}
+	return infra.PostprocessApp(ctx, infra.Deployment{IP: ip, Runtime: appDesc.Runtime}, app.AppBase)
+}
+
+// StopApp stops process running the app on remote host
+func (s *SSH) StopApp(ctx context.Context, name string) error {
+	appDesc, err := s.spec.App(name)
+	if err != nil {
+		return err
+	}
+	if appDesc.Runtime.Host == "" {
+		return nil
+	}
+	if err := s.stopApps(ctx, appDesc.Runtime.Host, map[string]*infra.AppDescription{name: appDesc}); err != nil {
+		return err
+	}
+	appDesc.Runtime.PID = 0
+	appDesc.Runtime.StartTime = 0
+	return nil
+}
+
+// StartApp starts new process running the app on the host it has been deployed to
+func (s *SSH) StartApp(ctx context.Context, name string) error {
+	appDesc, err := s.spec.App(name)
+	if err != nil {
+		return err
+	}
+	if len(appDesc.Command) == 0 {
+		return fmt.Errorf("command used to run app %s is not recorded in spec", name)
+	}
+	host := appDesc.Runtime.Host
+	if host == "" {
+		host = s.config.SSHHost
+	}
+	return s.startApp(ctx, host, name, appDesc)
+}
+
+// AppStatus returns status of app based on existence of its process on remote host
+func (s *SSH) AppStatus(ctx context.Context, name string) (infra.AppStatus, error) {
+	appDesc, err := s.spec.App(name)
+	if err != nil {
+		return "", err
+	}
+	if appDesc.Runtime.Host != "" && appDesc.Runtime.PID != 0 {
+		running, err := remoteProcessesRunning(ctx, appDesc.Runtime.Host, []infra.Runtime{appDesc.Runtime})
+		if err != nil {
+			return "", err
+		}
+		if running[0] {
+			return infra.AppStatusRunning, nil
+		}
+	}
+	if len(appDesc.Command) == 0 {
+		return infra.AppStatusMissing, nil
+	}
+	return infra.AppStatusStopped, nil
+}
+
+// hosts returns apps grouped by hosts they have been deployed to
+func (s *SSH) hosts() map[string]map[string]*infra.AppDescription {
+	hosts := map[string]map[string]*infra.AppDescription{}
+	for name, appDesc := range s.spec.Apps {
+		if host := appDesc.Runtime.Host; host != "" {
+			if hosts[host] == nil {
+				hosts[host] = map[string]*infra.AppDescription{}
+			}
+			hosts[host][name] = appDesc
+		}
+	}
+	return hosts
+}
+
+// startApp starts app on remote host in its own session, so it keeps running once ssh connection is closed.
+// Logs are written to file on remote host and streamed from there to the local one by ssh process running in the background.
+func (s *SSH) startApp(ctx context.Context, host, name string, appDesc *infra.AppDescription) error {
+	remoteLogPath := s.config.HomeDir + "/remote-logs/" + name + ".log"
+	var removeContainer string
+	if appDesc.Runtime.Image != "" {
+		// Container left behind by app killed previously would conflict with the new one
+		removeContainer = removeContainersScript(s.config, name)
+	}
+	// Remote log is truncated because it is streamed from the beginning, local one keeps logs of previous runs
+	script := fmt.Sprintf(`set -e
+mkdir -p "$(dirname %[1]s)"
+: > %[1]s
+%[3]ssetsid %[2]s >> %[1]s 2>&1 < /dev/null &
+cat /proc/$!/stat 2>/dev/null || true
+`, shellQuote(remoteLogPath), shellCommand(appDesc.Command...), removeContainer)
+	stat, err := runRemoteScript(ctx, host, script)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(stat)
+	if len(fields) == 0 {
+		return fmt.Errorf("app %s exited right after start, check logs in %s on host %s", name, remoteLogPath, host)
+	}
+	pID, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return err
+	}
+	proc, exists, err := parseProcessStat([]byte(stat))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("app %s exited right after start, check logs in %s on host %s", name, remoteLogPath, host)
+	}
+
+	logPath := s.config.LogDir + "/" + name + ".log"
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	// tail exits once the app exits, so streamer doesn't have to be stopped explicitly
+	streamCmd := exec.SSH(host, shellCommand("tail", "-n", "+1", "-F", "--pid", strconv.Itoa(pID), remoteLogPath))
+	streamCmd.Stdout = logFile
+	streamCmd.Stderr = logFile
+	// Streamer runs in its own session, so it survives localnet process
+	streamCmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := streamCmd.Start(); err != nil {
+		return err
+	}
+	stream, err := processRuntime(streamCmd.Process.Pid, logPath, "ssh")
+	if err != nil {
+		return err
+	}
+
+	appDesc.Runtime = infra.Runtime{
+		PID:             pID,
+		StartTime:       proc.StartTime,
+		Host:            host,
+		StreamPID:       stream.PID,
+		StreamStartTime: stream.StartTime,
+		LogPath:         logPath,
+		BinaryPath:      appDesc.Command[0],
+		Image:           appDesc.Runtime.Image,
+	}
+	return nil
+}
+
+// stopApps terminates apps running on the host gracefully, after timeout they are killed
+func (s *SSH) stopApps(ctx context.Context, host string, apps map[string]*infra.AppDescription) error {
+	runtimes := make([]infra.Runtime, 0, len(apps))
+	var containers []string
+	for name, appDesc := range apps {
+		runtimes = append(runtimes, appDesc.Runtime)
+		if appDesc.Runtime.Image != "" {
+			containers = append(containers, name)
+		}
+	}
+	running, err := remoteProcessesRunning(ctx, host, runtimes)
+	if err != nil {
+		return err
+	}
+
+	var pgIDs []string
+	var streamPIDs []int
+	for i, runtime := range runtimes {
+		if running[i] {
+			// App is the leader of its own process group, so all the processes started by it are terminated
+			pgIDs = append(pgIDs, "-"+strconv.Itoa(runtime.PID))
+		}
+		streamRunning, err := processRunning(infra.Runtime{PID: runtime.StreamPID, StartTime: runtime.StreamStartTime}, 0)
+		if err != nil {
+			return err
+		}
+		if streamRunning {
+			streamPIDs = append(streamPIDs, runtime.StreamPID)
+		}
+	}
+
+	if len(pgIDs) > 0 {
+		script := fmt.Sprintf(`pgids="%[1]s"
+kill -TERM -- $pgids 2>/dev/null
+for i in $(seq %[2]d); do
+  kill -0 -- $pgids 2>/dev/null || exit 0
+  sleep 0.1
+done
+kill -KILL -- $pgids 2>/dev/null
+exit 0
+`, strings.Join(pgIDs, " "), remoteKillTimeout/(100*time.Millisecond))
+		if _, err := runRemoteScript(ctx, host, script); err != nil {
+			return err
+		}
+	}
+	if len(containers) > 0 {
+		if _, err := runRemoteScript(ctx, host, removeContainersScript(s.config, containers...)); err != nil {
+			return err
+		}
+	}
+	if len(streamPIDs) == 0 {
+		return nil
+	}
+	return exec.Kill(ctx, streamPIDs)
+}
+
+// remoteProcessesRunning returns true for each process recorded in runtime handles which is still running on remote host.
+// Start time is compared because PID might have been reused by unrelated process.
+func remoteProcessesRunning(ctx context.Context, host string, runtimes []infra.Runtime) ([]bool, error) {
+	pIDs := make([]string, 0, len(runtimes))
+	for _, runtime := range runtimes {
+		pIDs = append(pIDs, strconv.Itoa(runtime.PID))
+	}
+	// Empty line is printed for process which doesn't exist
+	stats, err := runRemoteScript(ctx, host, fmt.Sprintf(`for pid in %s; do
+  cat /proc/$pid/stat 2>/dev/null || echo
+done
+`, strings.Join(pIDs, " ")))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(stats, "\n"), "\n")
+	if len(lines) != len(runtimes) {
+		return nil, fmt.Errorf("unexpected output received from host %s: %q", host, stats)
+	}
+	running := make([]bool, len(runtimes))
+	for i, line := range lines {
+		if line == "" || runtimes[i].PID == 0 {
+			continue
+		}
+		proc, exists, err := parseProcessStat([]byte(line))
+		if err != nil {
+			return nil, err
+		}
+		running[i] = exists && proc.StartTime == runtimes[i].StartTime
+	}
+	return running, nil
+}
+
+// copyToHost copies files and directories to the same paths on remote host.
+// Paths which don't exist on remote host yet are recorded in spec, so they are removed once environment is destroyed.
+func (s *SSH) copyToHost(ctx context.Context, host string, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	// For each path the topmost directory created by tar is printed
+	created, err := runRemoteScript(ctx, host, fmt.Sprintf(`for p in %s; do
+  [ -e "$p" ] && continue
+  while [ "$(dirname "$p")" != "$p" ] && [ ! -e "$(dirname "$p")" ]; do
+    p="$(dirname "$p")"
+  done
+  echo "$p"
+done
+`, shellCommand(paths...)))
+	if err != nil {
+		return err
+	}
+	var createdPaths []string
+	for _, path := range strings.Split(created, "\n") {
+		if path != "" {
+			createdPaths = append(createdPaths, path)
+		}
+	}
+	s.spec.AddRemoteFiles(host, createdPaths...)
+
+	pipeReader, pipeWriter := io.Pipe()
+	tarCmd := osexec.Command("tar", append([]string{"-c", "-P", "-f", "-", "--"}, paths...)...)
+	tarCmd.Stdout = pipeWriter
+	untarCmd := exec.SSH(host, "tar", "-x", "-P", "-f", "-")
+	untarCmd.Stdin = pipeReader
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		spawn("tar", parallel.Continue, func(ctx context.Context) error {
+			err := exec.Run(ctx, tarCmd)
+			_ = pipeWriter.CloseWithError(err)
+			return err
+		})
+		spawn("untar", parallel.Continue, func(ctx context.Context) error {
+			err := exec.Run(ctx, untarCmd)
+			// tar can't block on writing to the pipe nobody reads from
+			_ = pipeReader.Close()
+			return err
+		})
+		return nil
+	})
+}
+
+func uniqueStrings(values []string) []string {
+	unique := map[string]bool{}
+	var res []string
+	for _, value := range values {
+		if !unique[value] {
+			unique[value] = true
+			res = append(res, value)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// isLocalHost returns true if ssh host points to the local machine
+func isLocalHost(host string) bool {
+	host = strings.TrimPrefix(host, "ssh://")
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// runRemoteScript runs bash script on remote host and returns its output
+func runRemoteScript(ctx context.Context, host, script string) (string, error) {
+	buf := &bytes.Buffer{}
+	cmd := exec.SSH(host, "bash", "-s")
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stdout = buf
+	if err := exec.Run(ctx, cmd); err != nil {
+		return "", fmt.Errorf("running script on host %s failed: %w", host, err)
+	}
+	return buf.String(), nil
+}
+
+// shellCommand returns command with args quoted, so it may be passed to remote shell
+func shellCommand(args ...string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,156 @@
+package targets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/wojciech-sif/localnet/exec"
+	"github.com/wojciech-sif/localnet/infra"
+	"github.com/wojciech-sif/localnet/lib/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// minBackoff is the delay before app is restarted for the first time
+	minBackoff = time.Second
+
+	// maxBackoff is the maximum delay before app is restarted
+	maxBackoff = time.Minute
+
+	// stableRun is the time after which app is considered healthy, so backoff is reset once it exits
+	stableRun = time.Minute
+)
+
+// supervisorState is the state of supervised app stored by supervisor
+type supervisorState struct {
+	// Restarts is the number of times app has been restarted
+	Restarts int `json:"restarts"`
+
+	// ExitCode is the exit code returned by the app last time it exited
+	ExitCode int `json:"exitCode"`
+}
+
+// Supervise runs the command and restarts it with exponential backoff whenever it exits.
+// Number of restarts and last exit code are stored in statePath, so they may be recorded in spec.
+// Command is terminated gracefully once context is cancelled and supervisor exits without error then.
+func Supervise(ctx context.Context, statePath string, command []string) error {
+	log := logger.Get(ctx)
+
+	var state supervisorState
+	backoff := minBackoff
+	for {
+		cmd := osexec.Command(command[0], command[1:]...)
+		// App is killed if supervisor dies unexpectedly, so it's not left behind unsupervised
+		cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+
+		started := time.Now()
+		err := exec.Run(ctx, cmd)
+		if ctx.Err() != nil {
+			// Supervisor is stopped on purpose
+			return nil
+		}
+
+		var exitErr *osexec.ExitError
+		switch {
+		case err == nil:
+			state.ExitCode = 0
+		case errors.As(err, &exitErr):
+			state.ExitCode = exitCode(exitErr)
+		default:
+			return err
+		}
+		if time.Since(started) > stableRun {
+			backoff = minBackoff
+		}
+		state.Restarts++
+		if err := writeSupervisorState(statePath, state); err != nil {
+			return err
+		}
+
+		log.Info("App exited, restarting", zap.Strings("command", command), zap.Int("exitCode", state.ExitCode),
+			zap.Int("restarts", state.Restarts), zap.Duration("backoff", backoff))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// exitCode returns exit code of process, for process killed by signal it is 128 + signal number like in shell
+func exitCode(exitErr *osexec.ExitError) int {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+func writeSupervisorState(statePath string, state supervisorState) error {
+	stateRaw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return infra.WriteFileAtomic(statePath, stateRaw, 0o600)
+}
+
+// supervisorStatePath returns path to the file where supervisor stores state of the app
+func supervisorStatePath(config infra.Config, name string) string {
+	return config.HomeDir + "/supervisor/" + name + ".json"
+}
+
+// supervisedCommand returns command running the app by supervisor.
+// Supervisor is another instance of localnet running the app and restarting it whenever it exits.
+// State stored by previous supervisor of the app is removed.
+func supervisedCommand(config infra.Config, name string, command []string) ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	statePath := supervisorStatePath(config, name)
+	if err := os.MkdirAll(filepath.Dir(statePath), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(statePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return append([]string{exe, "supervise", statePath, "--"}, command...), nil
+}
+
+// refreshSupervisorState records restart count and exit code of supervised app in spec
+func refreshSupervisorState(config infra.Config, name string, appDesc *infra.AppDescription) error {
+	if !appDesc.Runtime.Supervised {
+		return nil
+	}
+	return readSupervisorState(supervisorStatePath(config, name), &appDesc.Runtime)
+}
+
+// readSupervisorState copies restart count and exit code stored by supervisor into runtime handles
+func readSupervisorState(statePath string, runtime *infra.Runtime) error {
+	stateRaw, err := ioutil.ReadFile(statePath)
+	switch {
+	case err == nil:
+	case errors.Is(err, os.ErrNotExist):
+		// App hasn't exited yet
+		return nil
+	default:
+		return err
+	}
+	var state supervisorState
+	if err := json.Unmarshal(stateRaw, &state); err != nil {
+		return err
+	}
+	runtime.Restarts = state.Restarts
+	runtime.ExitCode = state.ExitCode
+	return nil
+}
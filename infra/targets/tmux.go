@@ -5,8 +5,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	osexec "os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,46 +23,125 @@ func NewTMux(config infra.Config, spec *infra.Spec) infra.Target {
 	return &TMux{
 		config: config,
 		spec:   spec,
-		ipPool: infra.NewIPPool(config.Network),
 	}
 }
 
 // TMux is the target deploying apps to tmux session
 type TMux struct {
-	config infra.Config
-	spec   *infra.Spec
-	ipPool *infra.IPPool
+	config      infra.Config
+	spec        *infra.Spec
+	addressPool *infra.AddressPool
 
 	mu sync.Mutex // to protect tmux session
 }
 
 // Stop stops running applications
 func (t *TMux) Stop(ctx context.Context) error {
-	return t.sessionKill(ctx)
+	// Processes are terminated using cgroups first, so the ones which detached from tmux window are not left behind
+	cgroups := []string{}
+	for name, appDesc := range t.spec.Apps {
+		if err := refreshSupervisorState(t.config, name, appDesc); err != nil {
+			return err
+		}
+		if appDesc.Runtime.CGroup != "" {
+			cgroups = append(cgroups, appDesc.Runtime.CGroup)
+		}
+	}
+	if err := killCGroups(ctx, cgroups); err != nil {
+		return err
+	}
+	for _, cgroup := range cgroups {
+		if err := removeCGroup(cgroup); err != nil {
+			return err
+		}
+	}
+	if err := t.sessionKill(ctx); err != nil {
+		return err
+	}
+	return removeContainers(ctx, t.config, containerApps(t.spec)...)
 }
 
 // Destroy destroys running applications
 func (t *TMux) Destroy(ctx context.Context) error {
-	return t.Stop(ctx)
+	if err := t.Stop(ctx); err != nil {
+		return err
+	}
+	return removeEnvCGroups(ctx, t.config)
 }
 
 // Deploy deploys environment to tmux target
 func (t *TMux) Deploy(ctx context.Context, env infra.Set) error {
+	addressPool, err := infra.ClaimAddresses(ctx, t.config, t.spec)
+	if err != nil {
+		return err
+	}
+	t.addressPool = addressPool
 	if err := env.Deploy(ctx, t, t.spec); err != nil {
 		return err
 	}
+	return t.arrangeWindows(ctx)
+}
+
+// Attach attaches terminal to tmux session, if app is not empty its window is selected
+func (t *TMux) Attach(ctx context.Context, app string) error {
 	if t.config.TestingMode {
 		return nil
 	}
+	if app != "" {
+		appDesc, err := t.spec.App(app)
+		if err != nil {
+			return err
+		}
+		if appDesc.Runtime.WindowID == "" {
+			return fmt.Errorf("app %s is not running", app)
+		}
+		if err := exec.Run(ctx, exec.TMux("select-window", "-t", appDesc.Runtime.WindowID)); err != nil {
+			return err
+		}
+	}
 	return t.sessionAttach(ctx)
 }
 
+// ReportHealth presents health of apps in the status line of tmux session
+func (t *TMux) ReportHealth(ctx context.Context, health map[string]infra.Health) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if hasSession, err := t.sessionExists(ctx); err != nil || !hasSession {
+		return err
+	}
+	var status []string
+	for _, name := range t.windowOrder() {
+		if h, exists := health[name]; exists {
+			status = append(status, fmt.Sprintf("#[fg=%s]%s:%s#[default]", healthColors[h], name, h))
+		}
+	}
+	return exec.Run(ctx, exec.TMuxNoOut(
+		"set-option", "-t", t.config.EnvName, "status-right", strings.Join(status, " "), ";",
+		"set-option", "-t", t.config.EnvName, "status-right-length", "1000",
+	))
+}
+
+// ClearHealth restores default status line of tmux session, so health reported last doesn't stay there after detaching
+func (t *TMux) ClearHealth(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if hasSession, err := t.sessionExists(ctx); err != nil || !hasSession {
+		return err
+	}
+	return exec.Run(ctx, exec.TMuxNoOut(
+		"set-option", "-u", "-t", t.config.EnvName, "status-right", ";",
+		"set-option", "-u", "-t", t.config.EnvName, "status-right-length",
+	))
+}
+
 // DeployBinary starts binary file inside tmux session
 func (t *TMux) DeployBinary(ctx context.Context, app infra.Binary) error {
 	var ip net.IP
 	if app.RequiresIP {
 		var err error
-		ip, err = t.ipPool.Next()
+		ip, app.Ports, err = t.addressPool.Allocate(app.Name, app.Ports)
 		if err != nil {
 			return err
 		}
@@ -69,33 +150,217 @@ func (t *TMux) DeployBinary(ctx context.Context, app infra.Binary) error {
 	if err := infra.PreprocessApp(ctx, ip, t.config.AppDir, app.AppBase); err != nil {
 		return err
 	}
-	if err := t.sessionAddApp(ctx, app.Name, append([]string{app.Path}, app.Args...)...); err != nil {
+	appDesc, err := t.spec.App(app.Name)
+	if err != nil {
+		return err
+	}
+	appDesc.Command = append([]string{app.Path}, app.Args...)
+	appDesc.Resources = app.Resources
+	appDesc.Runtime, err = t.sessionAddApp(ctx, app.Name, appDesc)
+	if err != nil {
+		return err
+	}
+	return infra.PostprocessApp(ctx, infra.Deployment{IP: ip, Runtime: appDesc.Runtime}, app.AppBase)
+}
+
+// StopApp stops app running in tmux window, window is closed automatically once app exits
+func (t *TMux) StopApp(ctx context.Context, name string) error {
+	appDesc, err := t.spec.App(name)
+	if err != nil {
+		return err
+	}
+	if appDesc.Runtime.CGroup != "" {
+		if err := killCGroup(ctx, appDesc.Runtime.CGroup); err != nil {
+			return err
+		}
+		if err := removeCGroup(appDesc.Runtime.CGroup); err != nil {
+			return err
+		}
+	} else {
+		running, err := processRunning(appDesc.Runtime, 0)
+		if err != nil {
+			return err
+		}
+		if running {
+			if err := exec.Kill(ctx, []int{appDesc.Runtime.PID}); err != nil {
+				return err
+			}
+		}
+	}
+	// Window is kept open after app exits, so it has to be closed explicitly
+	if err := t.windowKill(ctx, appDesc.Runtime.WindowID); err != nil {
 		return err
 	}
-	return infra.PostprocessApp(ctx, ip, app.AppBase)
+	if appDesc.Runtime.Image != "" {
+		if err := removeContainers(ctx, t.config, name); err != nil {
+			return err
+		}
+	}
+	appDesc.Runtime.PID = 0
+	appDesc.Runtime.StartTime = 0
+	appDesc.Runtime.WindowID = ""
+	return nil
 }
 
-// DeployContainer starts container inside tmux session
+// StartApp starts app in new tmux window
+func (t *TMux) StartApp(ctx context.Context, name string) error {
+	appDesc, err := t.spec.App(name)
+	if err != nil {
+		return err
+	}
+	if len(appDesc.Command) == 0 {
+		return fmt.Errorf("command used to run app %s is not recorded in spec", name)
+	}
+	// Window of the app might be left open if app crashed
+	if err := t.windowKill(ctx, appDesc.Runtime.WindowID); err != nil {
+		return err
+	}
+	image := appDesc.Runtime.Image
+	if image != "" {
+		// Container left behind by app killed previously would conflict with the new one
+		if err := removeContainers(ctx, t.config, name); err != nil {
+			return err
+		}
+	}
+	appDesc.Runtime, err = t.sessionAddApp(ctx, name, appDesc)
+	appDesc.Runtime.Image = image
+	if err != nil {
+		return err
+	}
+	return t.arrangeWindows(ctx)
+}
+
+// AppStatus returns status of app based on existence of the process running in its tmux window
+func (t *TMux) AppStatus(ctx context.Context, name string) (infra.AppStatus, error) {
+	appDesc, err := t.spec.App(name)
+	if err != nil {
+		return "", err
+	}
+	if err := refreshSupervisorState(t.config, name, appDesc); err != nil {
+		return "", err
+	}
+	running, err := processRunning(appDesc.Runtime, 0)
+	if err != nil {
+		return "", err
+	}
+	if running {
+		// Script running in the window waits for restart if app exited
+		exited, err := t.windowExited(ctx, appDesc.Runtime.WindowID)
+		if err != nil {
+			return "", err
+		}
+		if !exited {
+			return infra.AppStatusRunning, nil
+		}
+	}
+	if len(appDesc.Command) == 0 {
+		return infra.AppStatusMissing, nil
+	}
+	return infra.AppStatusStopped, nil
+}
+
+// DeployContainer starts container in the foreground inside tmux session
 func (t *TMux) DeployContainer(ctx context.Context, app infra.Container) error {
-	panic("not implemented yet")
+	image, err := ensureImage(ctx, t.config.Registry, app)
+	if err != nil {
+		return err
+	}
+	ip, ports, err := t.addressPool.Allocate(app.Name, app.Ports)
+	if err != nil {
+		return err
+	}
+	app.Ports = ports
+
+	if err := infra.PreprocessApp(ctx, ip, t.config.AppDir, app.AppBase); err != nil {
+		return err
+	}
+	appDesc, err := t.spec.App(app.Name)
+	if err != nil {
+		return err
+	}
+	if err := removeContainers(ctx, t.config, app.Name); err != nil {
+		return err
+	}
+	appDesc.Command = containerCommand(t.config, app, image)
+	appDesc.Resources = app.Resources
+	appDesc.Runtime, err = t.sessionAddApp(ctx, app.Name, appDesc)
+	if err != nil {
+		return err
+	}
+	appDesc.Runtime.Image = image
+	return infra.PostprocessApp(ctx, infra.Deployment{IP: ip, Runtime: appDesc.Runtime}, app.AppBase)
 }
 
-func (t *TMux) sessionAddApp(ctx context.Context, name string, args ...string) error {
+// sessionAddApp runs app described in spec in new tmux window, runtime handles of the app are returned
+func (t *TMux) sessionAddApp(ctx context.Context, name string, appDesc *infra.AppDescription) (infra.Runtime, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	var supervised bool
+	switch t.config.RestartPolicy {
+	case RestartPolicyManual, "":
+		// App started by supervisor previously is supervised until environment is destroyed, like in direct target
+		supervised = appDesc.Runtime.Supervised
+	case RestartPolicyAlways:
+		supervised = true
+	default:
+		return infra.Runtime{}, fmt.Errorf("unknown restart policy %q", t.config.RestartPolicy)
+	}
+	command := appDesc.Command
+	if supervised {
+		var err error
+		command, err = supervisedCommand(t.config, name, command)
+		if err != nil {
+			return infra.Runtime{}, err
+		}
+	}
+
 	hasSession, err := t.sessionExists(ctx)
 	if err != nil {
-		return err
+		return infra.Runtime{}, err
+	}
+	cgroup, err := createAppCGroup(t.config, name, appDesc.Resources)
+	if err != nil {
+		return infra.Runtime{}, err
 	}
-	cmd := []string{
-		"bash", "-ce",
-		fmt.Sprintf(`exec %s > >(tee -a "%s/%s.log") 2>&1`, osexec.Command("", args...).String(), t.config.LogDir, name),
+	logPath := t.config.LogDir + "/" + name + ".log"
+	script := appScript(supervised, cgroup, osexec.Command(command[0], command[1:]...).String(), logPath)
+	cmd := []string{"bash", "-c", script}
+	// Session is created together with the first window
+	tmuxArgs := []string{"new-window", "-d", "-n", name, "-t", t.config.EnvName + ":"}
+	if !hasSession {
+		tmuxArgs = []string{"new-session", "-d", "-s", t.config.EnvName, "-n", name}
 	}
-	if hasSession {
-		return exec.Run(ctx, exec.TMux(append([]string{"new-window", "-d", "-n", name, "-t", t.config.EnvName + ":"}, cmd...)...))
+	buf := &bytes.Buffer{}
+	tmuxCmd := exec.TMux(append(append(tmuxArgs, "-P", "-F", "#{window_id} #{pane_pid}"), cmd...)...)
+	tmuxCmd.Stdout = buf
+	if err := exec.Run(ctx, tmuxCmd); err != nil {
+		return infra.Runtime{}, err
+	}
+
+	fields := strings.Fields(buf.String())
+	if len(fields) != 2 {
+		return infra.Runtime{}, fmt.Errorf("unexpected output of tmux: %q", buf.String())
 	}
-	return exec.Run(ctx, exec.TMux(append([]string{"new-session", "-d", "-s", t.config.EnvName, "-n", name}, cmd...)...))
+	runtime, err := processRuntime(int(must.Int64(strconv.ParseInt(fields[1], 10, 32))), logPath, appDesc.Command[0])
+	if err != nil {
+		return infra.Runtime{}, err
+	}
+	runtime.WindowID = fields[0]
+
+	// Window stays open even if script is killed, so the output of app may be inspected.
+	// Exit code of the app is shown in the status line until it is restarted.
+	statusFormat := "#I:#W#{?" + exitCodeOption + ",[exit #{" + exitCodeOption + "}],}#{?window_flags,#{window_flags}, }"
+	if err := exec.Run(ctx, exec.TMux(
+		"set-option", "-w", "-t", runtime.WindowID, "remain-on-exit", "on", ";",
+		"set-option", "-w", "-t", runtime.WindowID, "window-status-format", statusFormat, ";",
+		"set-option", "-w", "-t", runtime.WindowID, "window-status-current-format", statusFormat,
+	)); err != nil {
+		return infra.Runtime{}, err
+	}
+	runtime.CGroup = cgroup
+	runtime.Supervised = supervised
+	return runtime, nil
 }
 
 func (t *TMux) sessionAttach(ctx context.Context) error {
@@ -107,7 +372,7 @@ func (t *TMux) sessionAttach(ctx context.Context) error {
 
 func (t *TMux) sessionKill(ctx context.Context) error {
 	// When using just `tmux kill-session` tmux sends SIGHUP to process, but we need SIGTERM.
-	// After sending it to all apps, session is terminated automatically.
+	// Windows are kept open after apps exit, so session is killed once all of them are terminated.
 
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -116,25 +381,189 @@ func (t *TMux) sessionKill(ctx context.Context) error {
 		return err
 	}
 	pids, err := t.sessionPIDs(ctx)
-	if err != nil || len(pids) == 0 {
+	if err != nil {
+		return err
+	}
+	if len(pids) > 0 {
+		if err := exec.Kill(ctx, pids); err != nil {
+			return err
+		}
+	}
+	return exec.Run(ctx, exec.TMuxNoOut("kill-session", "-t", t.config.EnvName))
+}
+
+// windowExited returns true if app running in the window exited and it waits for restart
+func (t *TMux) windowExited(ctx context.Context, windowID string) (bool, error) {
+	if windowID == "" {
+		return false, nil
+	}
+	buf := &bytes.Buffer{}
+	cmd := exec.TMux("show-options", "-w", "-q", "-v", "-t", windowID, exitCodeOption)
+	cmd.Stdout = buf
+	cmd.Stderr = io.Discard
+	if err := exec.Run(ctx, cmd); err != nil {
+		if errors.Is(err, ctx.Err()) {
+			return false, err
+		}
+		// Window doesn't exist
+		return false, nil
+	}
+	return strings.TrimSpace(buf.String()) != "", nil
+}
+
+// windowOrder returns names of apps having tmux windows in the order windows should be arranged.
+// Apps listed in config come first, the other ones are sorted by name.
+func (t *TMux) windowOrder() []string {
+	var names []string
+	for name, appDesc := range t.spec.Apps {
+		if appDesc.Runtime.WindowID != "" {
+			names = append(names, name)
+		}
+	}
+	position := map[string]int{}
+	for i, name := range t.config.WindowOrder {
+		position[name] = i - len(t.config.WindowOrder)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if position[names[i]] != position[names[j]] {
+			return position[names[i]] < position[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// arrangeWindows orders windows of apps and, if dashboard layout is used, recreates overview window presenting logs of all apps
+func (t *TMux) arrangeWindows(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if hasSession, err := t.sessionExists(ctx); err != nil || !hasSession {
+		return err
+	}
+
+	// Windows recorded in spec don't exist if session has been recreated in the meantime
+	buf := &bytes.Buffer{}
+	cmd := exec.TMux("list-windows", "-t", t.config.EnvName, "-F", "#{window_id}")
+	cmd.Stdout = buf
+	if err := exec.Run(ctx, cmd); err != nil {
 		return err
 	}
-	return exec.Kill(ctx, pids)
+	existing := map[string]bool{}
+	for _, windowID := range strings.Fields(buf.String()) {
+		existing[windowID] = true
+	}
+	var names []string
+	for _, name := range t.windowOrder() {
+		if existing[t.spec.Apps[name].Runtime.WindowID] {
+			names = append(names, name)
+		}
+	}
+
+	var windowIDs []string
+	switch t.config.TMuxLayout {
+	case TMuxLayoutWindows, "":
+	case TMuxLayoutDashboard:
+		overviewID, err := t.overviewWindow(ctx, names)
+		if err != nil {
+			return err
+		}
+		if overviewID != "" {
+			windowIDs = append(windowIDs, overviewID)
+		}
+	default:
+		return fmt.Errorf("unknown tmux layout %q", t.config.TMuxLayout)
+	}
+	for _, name := range names {
+		windowIDs = append(windowIDs, t.spec.Apps[name].Runtime.WindowID)
+	}
+	if len(windowIDs) == 0 {
+		return nil
+	}
+
+	// Windows are moved to free indexes first, then they are renumbered, so they are placed in the right order
+	var args []string
+	for i, windowID := range windowIDs {
+		args = append(args, "move-window", "-s", windowID, "-t", fmt.Sprintf("%s:%d", t.config.EnvName, windowIndexOffset+i), ";")
+	}
+	args = append(args, "move-window", "-r", "-t", t.config.EnvName)
+	return exec.Run(ctx, exec.TMuxNoOut(args...))
+}
+
+// overviewWindow creates window containing pane presenting logs for each app, existing overview window is replaced
+func (t *TMux) overviewWindow(ctx context.Context, names []string) (string, error) {
+	if err := t.windowKill(ctx, t.config.EnvName+":"+overviewWindowName); err != nil {
+		return "", err
+	}
+
+	var windowID string
+	for _, name := range names {
+		logPath := t.spec.Apps[name].Runtime.LogPath
+		if logPath == "" {
+			logPath = t.config.LogDir + "/" + name + ".log"
+		}
+		tmuxArgs := []string{"split-window", "-d", "-t", windowID}
+		if windowID == "" {
+			tmuxArgs = []string{"new-window", "-d", "-n", overviewWindowName, "-t", t.config.EnvName + ":"}
+		}
+		buf := &bytes.Buffer{}
+		cmd := exec.TMux(append(tmuxArgs, "-P", "-F", "#{window_id} #{pane_id}", "tail", "-n", "100", "-F", logPath)...)
+		cmd.Stdout = buf
+		if err := exec.Run(ctx, cmd); err != nil {
+			return "", err
+		}
+		fields := strings.Fields(buf.String())
+		if len(fields) != 2 {
+			return "", fmt.Errorf("unexpected output of tmux: %q", buf.String())
+		}
+		windowID = fields[0]
+		// Layout is recalculated after adding each pane, otherwise there is no space for the next one
+		if err := exec.Run(ctx, exec.TMux(
+			"select-pane", "-t", fields[1], "-T", name, ";",
+			"select-layout", "-t", windowID, "tiled",
+		)); err != nil {
+			return "", err
+		}
+	}
+	if windowID == "" {
+		return "", nil
+	}
+	return windowID, exec.Run(ctx, exec.TMux(
+		"set-option", "-w", "-t", windowID, "pane-border-status", "top", ";",
+		"set-option", "-w", "-t", windowID, "pane-border-format", " #{pane_title} ",
+	))
+}
+
+func (t *TMux) windowKill(ctx context.Context, windowID string) error {
+	if windowID == "" {
+		return nil
+	}
+	// Window doesn't exist if session has been killed in the meantime
+	err := exec.Run(ctx, exec.TMuxNoOut("kill-window", "-t", windowID))
+	if err != nil && errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return nil
 }
 
 func (t *TMux) sessionPIDs(ctx context.Context) ([]int, error) {
 	buf := &bytes.Buffer{}
-	cmd := exec.TMux("list-windows", "-t", t.config.EnvName, "-F", "#{pane_pid}")
+	cmd := exec.TMux("list-windows", "-t", t.config.EnvName, "-F", "#{pane_dead} #{pane_pid}")
 	cmd.Stdout = buf
 	if err := exec.Run(ctx, cmd); err != nil {
 		return nil, err
 	}
 	pids := []int{}
-	for _, pidStr := range strings.Split(buf.String(), "\n") {
-		if pidStr == "" {
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line == "" {
 			break
 		}
-		pids = append(pids, int(must.Int64(strconv.ParseInt(pidStr, 10, 32))))
+		// Process of dead pane doesn't exist anymore
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] == "1" {
+			continue
+		}
+		pids = append(pids, int(must.Int64(strconv.ParseInt(fields[1], 10, 32))))
 	}
 	return pids, nil
 }
@@ -146,3 +575,65 @@ func (t *TMux) sessionExists(ctx context.Context) (bool, error) {
 	}
 	return err == nil, nil
 }
+
+const (
+	// TMuxLayoutWindows means each app runs in its own window
+	TMuxLayoutWindows = "windows"
+
+	// TMuxLayoutDashboard means overview window presenting logs of all apps is created before windows of apps
+	TMuxLayoutDashboard = "dashboard"
+
+	// overviewWindowName is the name of the window presenting logs of all apps
+	overviewWindowName = "overview"
+
+	// windowIndexOffset is the index windows are temporarily moved to before they are renumbered
+	windowIndexOffset = 10000
+
+	// RestartPolicyManual means app exited in tmux window is restarted once Enter is pressed
+	RestartPolicyManual = "manual"
+
+	// RestartPolicyAlways means app is run in tmux window by supervisor restarting it automatically with backoff,
+	// the same one which is used by direct target if --supervise is set
+	RestartPolicyAlways = "always"
+
+	// exitCodeOption is the tmux window option storing exit code of the app, it is shown in status line
+	exitCodeOption = "@exit_code"
+)
+
+var healthColors = map[infra.Health]string{
+	infra.HealthHealthy:   "green",
+	infra.HealthUnhealthy: "yellow",
+	infra.HealthRunning:   "default",
+	infra.HealthExited:    "red",
+}
+
+// appScript returns bash script running the app in tmux window.
+// Supervised app is restarted by supervisor, script only sends output of the app to the log file.
+// Otherwise, script waits until Enter is pressed to restart app once it exits.
+// App runs as background job with its own process group, so script may forward signals to it.
+// Ctrl-C and Ctrl-\ typed in the window are delivered to the app, SIGTERM stops both the app and the script.
+func appScript(supervised bool, cgroup, command, logPath string) string {
+	if supervised {
+		return fmt.Sprintf(`%sexec %s > >(tee -a "%s") 2>&1`, cgroupCommand(cgroup), command, logPath)
+	}
+	return fmt.Sprintf(`%sset -m
+trap 'kill -INT $app 2>/dev/null' INT
+trap 'kill -QUIT $app 2>/dev/null' QUIT
+trap 'stop=1; kill -TERM $app 2>/dev/null' TERM HUP
+while true; do
+  tmux set-option -w -t "$TMUX_PANE" -u %[4]s
+  %[2]s > >(tee -a "%[3]s") 2>&1 &
+  app=$!
+  wait $app; code=$?
+  # Wait is interrupted by signals forwarded to the app
+  while kill -0 $app 2>/dev/null; do wait $app; code=$?; done
+  [ -n "$stop" ] && exit $code
+  tmux set-option -w -t "$TMUX_PANE" %[4]s $code
+  echo
+  echo "App exited with code $code"
+  # Signals are handled by bash after read returns, so it times out periodically
+  echo "Press Enter to restart"
+  until read -r -t 1 || [ $? -le 128 ] || [ -n "$stop" ]; do :; done
+  [ -n "$stop" ] && exit $code
+done`, cgroupCommand(cgroup), command, logPath, exitCodeOption)
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/ridge/must"
+	"github.com/ridge/parallel"
 )
 
 // App is the interface exposed by application
@@ -23,27 +24,127 @@ type App interface {
 	Deploy(ctx context.Context, target AppTarget) error
 }
 
+// DependencyCapable represents application which has to be deployed after other apps
+type DependencyCapable interface {
+	// Dependencies returns apps which have to be deployed before this one
+	Dependencies() []HealthCheckCapable
+}
+
+// maxParallelDeployments is the maximum number of apps being deployed at the same time
+const maxParallelDeployments = 4
+
 // Set is the environment to deploy
 type Set []App
 
-// Deploy deploys app in environment to the target
+// Deploy deploys app in environment to the target.
+// Apps are deployed concurrently, each one as soon as all the apps it depends on are deployed.
 func (s Set) Deploy(ctx context.Context, t AppTarget, spec *Spec) error {
+	graph := s.Graph()
+	if err := graph.verifyUniqueness(); err != nil {
+		return err
+	}
+	// Deployment would hang forever if there was a cycle
+	if err := graph.verifyAcyclic(); err != nil {
+		return err
+	}
+
+	deployed := map[string]chan struct{}{}
 	for _, app := range s {
-		if appSpec, exists := spec.Apps[app.Name()]; exists && appSpec.Running {
-			continue
-		}
-		if err := app.Deploy(ctx, t); err != nil {
-			return err
-		}
-		spec.Apps[app.Name()].Running = true
+		deployed[app.Name()] = make(chan struct{})
 	}
-	return nil
+	workers := make(chan struct{}, maxParallelDeployments)
+	return parallel.Run(ctx, func(ctx context.Context, spawn parallel.SpawnFn) error {
+		for _, app := range s {
+			app := app
+			spawn("deploy-"+app.Name(), parallel.Continue, func(ctx context.Context) error {
+				for _, dep := range graph.Dependencies[app.Name()] {
+					depDeployed, exists := deployed[dep]
+					if !exists {
+						// Dependencies which are not part of the set are expected to be deployed already
+						continue
+					}
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-depDeployed:
+					}
+				}
+				if appSpec, exists := spec.Apps[app.Name()]; !exists || !appSpec.Running {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case workers <- struct{}{}:
+					}
+					err := app.Deploy(ctx, t)
+					<-workers
+					if err != nil {
+						return err
+					}
+					spec.Apps[app.Name()].Running = true
+				}
+				close(deployed[app.Name()])
+				return nil
+			})
+		}
+		return nil
+	})
 }
 
 // Deployment contains info about deployed application
 type Deployment struct {
 	// IP is the IP address assigned to application
 	IP net.IP
+
+	// Ports are the named ports application listens on
+	Ports map[string]int
+
+	// Runtime contains handles to the running application recorded by target
+	Runtime Runtime
+}
+
+// Runtime contains handles to the running application, fields not used by target are left empty
+type Runtime struct {
+	// PID is the ID of process running the app - used by tmux, direct and ssh targets
+	PID int `json:"pid,omitempty"`
+
+	// StartTime is the start time of process in clock ticks since boot, together with PID it identifies process uniquely
+	StartTime uint64 `json:"startTime,omitempty"`
+
+	// Host is the ssh host the app runs on - used by ssh target
+	Host string `json:"host,omitempty"`
+
+	// StreamPID is the ID of local process streaming logs of the app from remote host - used by ssh target
+	StreamPID int `json:"streamPID,omitempty"`
+
+	// StreamStartTime is the start time of process streaming logs in clock ticks since boot
+	StreamStartTime uint64 `json:"streamStartTime,omitempty"`
+
+	// WindowID is the ID of tmux window running the app - used by tmux target
+	WindowID string `json:"windowID,omitempty"`
+
+	// ContainerID is the ID of container running the app - used by docker target
+	ContainerID string `json:"containerID,omitempty"`
+
+	// LogPath is the path to the file where logs of the app are stored
+	LogPath string `json:"logPath,omitempty"`
+
+	// BinaryPath is the path to the binary executed to run the app
+	BinaryPath string `json:"binaryPath,omitempty"`
+
+	// Image is the container image the app has been started from
+	Image string `json:"image,omitempty"`
+
+	// CGroup is the path to cgroup v2 the processes of the app are placed in - used by tmux and direct targets
+	CGroup string `json:"cgroup,omitempty"`
+
+	// Supervised means app is run by supervisor restarting it whenever it exits - used by direct target
+	Supervised bool `json:"supervised,omitempty"`
+
+	// Restarts is the number of times supervisor restarted the app
+	Restarts int `json:"restarts,omitempty"`
+
+	// ExitCode is the exit code returned by the app last time it exited unexpectedly
+	ExitCode int `json:"exitCode,omitempty"`
 }
 
 // Target represents target of deployment from the perspective of localnet
@@ -56,6 +157,21 @@ type Target interface {
 
 	// Destroy destroys apps in the environment
 	Destroy(ctx context.Context) error
+
+	// StopApp stops single app running in the environment
+	StopApp(ctx context.Context, name string) error
+
+	// StartApp starts single app which was deployed to the environment and stopped later
+	StartApp(ctx context.Context, name string) error
+
+	// AppStatus returns the real status of app deployed to the environment
+	AppStatus(ctx context.Context, name string) (AppStatus, error)
+}
+
+// AttachCapable represents target terminal may be attached to
+type AttachCapable interface {
+	// Attach attaches terminal to the running environment, if app is not empty its output is presented
+	Attach(ctx context.Context, app string) error
 }
 
 // AppTarget represents target of deployment from the perspective of application
@@ -107,6 +223,10 @@ type AppBase struct {
 	// Args are args passed to binary
 	Args []string
 
+	// Ports are the named ports app listens on together with their default values.
+	// Target may allocate other ones, args and files refer to them in templates using {{ .Ports.<name> }}.
+	Ports map[string]int
+
 	// Files are the files to be created for application
 	Files []File
 
@@ -121,6 +241,23 @@ type AppBase struct {
 
 	// PostFunc is called after app is deployed
 	PostFunc PostprocessFunc
+
+	// Resources are the limits of resources app may use - applied by tmux and direct targets
+	Resources Resources
+}
+
+// Resources are the limits of resources available to app
+type Resources struct {
+	// CPUs is the number of CPUs app may use, fractions are allowed, 0 means no limit
+	CPUs float64 `json:"cpus,omitempty"`
+
+	// Memory is the amount of memory in bytes app may use, 0 means no limit
+	Memory uint64 `json:"memory,omitempty"`
+}
+
+// Limited returns true if any limit is set
+func (r Resources) Limited() bool {
+	return r.CPUs > 0 || r.Memory > 0
 }
 
 // Binary represents binary file to be deployed
@@ -141,37 +278,71 @@ type Container struct {
 	// Image is the url of the container image
 	Image string
 
-	// Tag is the tag of the image
+	// Tag is the tag of the image, latest is used if empty
 	Tag string
 }
 
-// NewSpec returns new spec
-func NewSpec(config Config) *Spec {
+// NewSpec returns new spec.
+// Environment is locked until spec is released or process exits, so no other localnet process may modify it.
+// ErrEnvBusy is returned if environment is locked by another localnet process.
+func NewSpec(config Config) (*Spec, error) {
+	lock, err := lockEnv(config.HomeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := readSpec(config, lock)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return spec, nil
+}
+
+func readSpec(config Config, lock *os.File) (*Spec, error) {
 	specFile := config.HomeDir + "/spec.json"
 	specRaw, err := ioutil.ReadFile(specFile)
 	switch {
 	case err == nil:
 		spec := &Spec{
 			specFile: specFile,
+			lock:     lock,
+		}
+		specRaw, err = migrateSpec(specRaw)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(specRaw, spec); err != nil {
+			return nil, err
+		}
+		if len(spec.Apps) == 0 {
+			// Environment has been stopped, so only IPs allocated to apps are taken from spec
+			spec.Target = config.Target
+			spec.Set = config.SetName
+			spec.Apps = map[string]*AppDescription{}
+			if config.Target == "direct" {
+				spec.PGID = os.Getpid()
+			}
 		}
-		must.OK(json.Unmarshal(specRaw, spec))
 		if spec.Target != config.Target {
-			panic(fmt.Sprintf("target mismatch, spec: %s, config: %s", spec.Target, config.Target))
+			return nil, fmt.Errorf("target mismatch, spec: %s, config: %s", spec.Target, config.Target)
 		}
 		if spec.Env != config.EnvName {
-			panic(fmt.Sprintf("env mismatch, spec: %s, config: %s", spec.Env, config.EnvName))
+			return nil, fmt.Errorf("env mismatch, spec: %s, config: %s", spec.Env, config.EnvName)
 		}
 		if spec.Set != config.SetName {
-			panic(fmt.Sprintf("set mismatch, spec: %s, config: %s", spec.Set, config.SetName))
+			return nil, fmt.Errorf("set mismatch, spec: %s, config: %s", spec.Set, config.SetName)
 		}
-		return spec
+		return spec, nil
 	case errors.Is(err, os.ErrNotExist):
 	default:
-		panic(err)
+		return nil, err
 	}
 
 	spec := &Spec{
 		specFile: specFile,
+		lock:     lock,
+		Version:  SpecVersion,
 		Target:   config.Target,
 		Set:      config.SetName,
 		Env:      config.EnvName,
@@ -180,12 +351,54 @@ func NewSpec(config Config) *Spec {
 	if config.Target == "direct" {
 		spec.PGID = os.Getpid()
 	}
-	return spec
+	return spec, nil
+}
+
+// NewVolatileSpec returns new spec which is kept in memory only, it is used when apps are not deployed for real
+func NewVolatileSpec(config Config) *Spec {
+	return &Spec{
+		Version: SpecVersion,
+		Target:  config.Target,
+		Set:     config.SetName,
+		Env:     config.EnvName,
+		Apps:    map[string]*AppDescription{},
+	}
+}
+
+// RecordedTargetAndSet returns target and set recorded in spec of existing environment.
+// Empty strings are returned if environment hasn't been created yet or it has been stopped.
+func RecordedTargetAndSet(homeDir string) (target string, set string, err error) {
+	specRaw, err := ioutil.ReadFile(homeDir + "/spec.json")
+	switch {
+	case err == nil:
+	case errors.Is(err, os.ErrNotExist):
+		return "", "", nil
+	default:
+		return "", "", err
+	}
+
+	spec := struct {
+		Target string                     `json:"target"`
+		Set    string                     `json:"set"`
+		Apps   map[string]json.RawMessage `json:"apps"`
+	}{}
+	if err := json.Unmarshal(specRaw, &spec); err != nil {
+		return "", "", err
+	}
+	if len(spec.Apps) == 0 {
+		// Stopped environment may be started using any target and set
+		return "", "", nil
+	}
+	return spec.Target, spec.Set, nil
 }
 
 // Spec describes running environment
 type Spec struct {
 	specFile string
+	lock     *os.File
+
+	// Version is the version of spec format
+	Version int `json:"version"`
 
 	// PGID stores process group ID used to run apps - used only by direct target
 	PGID int `json:"pgid,omitempty"`
@@ -201,6 +414,21 @@ type Spec struct {
 
 	mu sync.Mutex
 
+	// Network is the network IPs are allocated to apps from, it is claimed by environment until it is destroyed
+	Network string `json:"network,omitempty"`
+
+	// IPs are the IPs allocated to apps, they are kept when environment is stopped and released when it is destroyed
+	IPs map[string]net.IP `json:"ips,omitempty"`
+
+	// PortRange is the range of ports allocated to apps in ports allocation mode, it is claimed by environment until it is destroyed
+	PortRange *PortRange `json:"portRange,omitempty"`
+
+	// Ports are the ports allocated to apps in ports allocation mode, they are kept the same way as IPs
+	Ports map[string]map[string]int `json:"ports,omitempty"`
+
+	// RemoteFiles are the paths created on remote hosts outside home dir, they are removed when environment is destroyed - used by ssh target
+	RemoteFiles map[string][]string `json:"remoteFiles,omitempty"`
+
 	// Apps is the description of running apps
 	Apps map[string]*AppDescription `json:"apps"`
 }
@@ -224,6 +452,43 @@ func (s *Spec) DescribeApp(appType string, name string) *AppDescription {
 	return appDesc
 }
 
+// App returns description of app existing in the environment
+func (s *Spec) App(name string) (*AppDescription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, exists := s.Apps[name]
+	if !exists {
+		return nil, fmt.Errorf("app %s does not exist in environment", name)
+	}
+	return app, nil
+}
+
+// AddRemoteFiles records paths created on remote host outside home dir
+func (s *Spec) AddRemoteFiles(host string, paths ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(paths) == 0 {
+		return
+	}
+	if s.RemoteFiles == nil {
+		s.RemoteFiles = map[string][]string{}
+	}
+	for _, path := range paths {
+		exists := false
+		for _, p := range s.RemoteFiles[host] {
+			if p == path {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			s.RemoteFiles[host] = append(s.RemoteFiles[host], path)
+		}
+	}
+}
+
 // String converts spec to json string
 func (s *Spec) String() string {
 	return string(must.Bytes(json.MarshalIndent(s, "", "  ")))
@@ -231,17 +496,89 @@ func (s *Spec) String() string {
 
 // Save saves spec into file
 func (s *Spec) Save() error {
-	return ioutil.WriteFile(s.specFile, []byte(s.String()), 0o600)
+	if s.specFile == "" {
+		return errors.New("volatile spec can't be saved")
+	}
+	if s.lock == nil {
+		return errors.New("spec can't be saved because environment lock has been released")
+	}
+	return WriteFileAtomic(s.specFile, []byte(s.String()), 0o600)
 }
 
-// Reset removes spec size
-func (s *Spec) Reset() error {
-	if err := os.Remove(s.specFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+// Release releases the environment lock, spec can't be saved afterwards
+func (s *Spec) Release() error {
+	if s.lock == nil {
+		return nil
+	}
+	err := s.lock.Close()
+	s.lock = nil
+	return err
+}
+
+// Refresh replaces description of apps with the one stored in spec file. It is used after environment lock is released,
+// when other localnet processes may start, stop and add apps. Spec file is read without taking the lock, it is always
+// written atomically. If environment has been stopped or destroyed in the meantime, no apps are described.
+func (s *Spec) Refresh() error {
+	if s.lock != nil {
+		return errors.New("spec can't be refreshed because environment is locked by this process")
+	}
+	specRaw, err := ioutil.ReadFile(s.specFile)
+	stored := struct {
+		PGID int                        `json:"pgid"`
+		Apps map[string]*AppDescription `json:"apps"`
+	}{}
+	switch {
+	case err == nil:
+		specRaw, err = migrateSpec(specRaw)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(specRaw, &stored); err != nil {
+			return err
+		}
+	case !errors.Is(err, os.ErrNotExist):
 		return err
 	}
+	if stored.Apps == nil {
+		stored.Apps = map[string]*AppDescription{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.PGID = stored.PGID
+	s.Apps = stored.Apps
 	return nil
 }
 
+// Reset removes description of apps from spec.
+// Network, port range, IPs and ports allocated to apps are kept, so they get the same addresses once environment is started again.
+// Paths created on remote hosts are kept too, so they may be removed once environment is destroyed.
+func (s *Spec) Reset() error {
+	if s.lock == nil {
+		return errors.New("spec can't be reset because environment lock has been released")
+	}
+	if len(s.IPs) == 0 && s.Network == "" && len(s.Ports) == 0 && s.PortRange == nil && len(s.RemoteFiles) == 0 {
+		if err := os.Remove(s.specFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	stopped := &Spec{
+		Version:     SpecVersion,
+		Target:      s.Target,
+		Set:         s.Set,
+		Env:         s.Env,
+		Network:     s.Network,
+		IPs:         s.IPs,
+		PortRange:   s.PortRange,
+		Ports:       s.Ports,
+		RemoteFiles: s.RemoteFiles,
+		Apps:        map[string]*AppDescription{},
+	}
+	return WriteFileAtomic(s.specFile, []byte(stopped.String()), 0o600)
+}
+
 // AppDescription describes app running in environment
 type AppDescription struct {
 	// Type is the type of app
@@ -253,6 +590,18 @@ type AppDescription struct {
 	// Running indicates if apps are running
 	Running bool `json:"running"`
 
+	// Stopped indicates that app was deployed and then stopped on demand, so it should be started again instead of being redeployed
+	Stopped bool `json:"stopped,omitempty"`
+
+	// Command is the preprocessed command used to run the app - used by tmux, direct and ssh targets to start app again
+	Command []string `json:"command,omitempty"`
+
+	// Resources are the limits of resources applied to the app - used by tmux and direct targets to start app again
+	Resources Resources `json:"resources,omitempty"`
+
+	// Runtime contains handles to the running app recorded by target
+	Runtime Runtime `json:"runtime"`
+
 	mu sync.Mutex
 
 	// Endpoints describe endpoints exposed by application
@@ -262,6 +611,18 @@ type AppDescription struct {
 	Params map[string]string `json:"params,omitempty"`
 }
 
+// Status returns status of app recorded in spec
+func (a *AppDescription) Status() AppStatus {
+	switch {
+	case a.Running:
+		return AppStatusRunning
+	case a.Stopped:
+		return AppStatusStopped
+	default:
+		return AppStatusMissing
+	}
+}
+
 // AddEndpoint adds endpoint to app description
 func (a *AppDescription) AddEndpoint(name, endpoint string) {
 	a.mu.Lock()
@@ -277,6 +638,14 @@ func (a *AppDescription) AddEndpoint(name, endpoint string) {
 	a.Endpoints[name] = endpoint
 }
 
+// Endpoint returns endpoint exposed by app, empty string is returned if it does not exist
+func (a *AppDescription) Endpoint(name string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.Endpoints[name]
+}
+
 // AddParam adds parameter to app description
 func (a *AppDescription) AddParam(name, value string) {
 	a.mu.Lock()
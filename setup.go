@@ -1,34 +1,50 @@
 package localnet
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"path/filepath"
 	"regexp"
 
-	"github.com/ridge/must"
 	"github.com/spf13/cobra"
 	"github.com/wojciech-malota-wojcik/ioc"
 	"github.com/wojciech-sif/localnet/infra"
 	"github.com/wojciech-sif/localnet/infra/apps"
 	"github.com/wojciech-sif/localnet/infra/targets"
 	"github.com/wojciech-sif/localnet/lib/logger"
+	"go.uber.org/zap"
 )
 
 // IoC configures IoC container
 func IoC(c *ioc.Container) {
 	c.Singleton(NewCmdFactory)
 	c.Singleton(NewConfigFactory)
-	c.Singleton(infra.NewSpec)
-	c.Transient(func(configF *ConfigFactory) infra.Config {
-		return configF.Config()
+	c.Singleton(func(config infra.Config) *infra.Spec {
+		if config.DryRun {
+			return infra.NewVolatileSpec(config)
+		}
+		spec, err := infra.NewSpec(config)
+		mustProvide(err)
+		return spec
+	})
+	c.Transient(func(ctx context.Context, configF *ConfigFactory, cmd *cobra.Command) infra.Config {
+		config, err := configF.Config(ctx, cmd)
+		mustProvide(err)
+		return config
 	})
 	c.Transient(apps.NewFactory)
 	c.TransientNamed("dev", DevSet)
 	c.TransientNamed("full", FullSet)
 	c.TransientNamed("tests", TestsSet)
-	c.Transient(func(c *ioc.Container, config infra.Config) infra.Set {
+	c.Transient(func(c *ioc.Container, config infra.Config, appF *apps.Factory) infra.Set {
+		if apps.IsSetFile(config.SetName) {
+			set, err := appF.SetFromFile(config.SetName)
+			mustProvide(err)
+			return set
+		}
 		var set infra.Set
 		c.ResolveNamed(config.SetName, &set)
 		return set
@@ -36,6 +52,7 @@ func IoC(c *ioc.Container) {
 	c.TransientNamed("direct", targets.NewDirect)
 	c.TransientNamed("tmux", targets.NewTMux)
 	c.TransientNamed("docker", targets.NewDocker)
+	c.TransientNamed("ssh", targets.NewSSH)
 	c.Transient(func(c *ioc.Container, config infra.Config) infra.Target {
 		var target infra.Target
 		c.ResolveNamed(config.Target, &target)
@@ -55,15 +72,48 @@ type CmdFactory struct {
 	c *ioc.Container
 }
 
+// Args are positional arguments passed to command
+type Args []string
+
 // Cmd returns function compatible with RunE
 func (f *CmdFactory) Cmd(cmdFunc interface{}) func(cmd *cobra.Command, args []string) error {
-	return func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) (retErr error) {
+		defer func() {
+			if r := recover(); r != nil {
+				pErr, ok := r.(providerError)
+				if !ok {
+					panic(r)
+				}
+				retErr = pErr.err
+			}
+		}()
+
+		f.c.Singleton(func() Args {
+			return args
+		})
+		f.c.Singleton(func() *cobra.Command {
+			return cmd
+		})
+
 		var err error
 		f.c.Call(cmdFunc, &err)
 		return err
 	}
 }
 
+// providerError carries error of IoC provider to the command being executed
+type providerError struct {
+	err error
+}
+
+// mustProvide is used by IoC providers, which can't return errors, to fail the command being executed.
+// Error is returned by the command like any other one, instead of crashing the process.
+func mustProvide(err error) {
+	if err != nil {
+		panic(providerError{err: err})
+	}
+}
+
 // NewConfigFactory creates new ConfigFactory
 func NewConfigFactory() *ConfigFactory {
 	return &ConfigFactory{}
@@ -86,64 +136,185 @@ type ConfigFactory struct {
 	// BinDir is the path where all binaries are present
 	BinDir string
 
-	// Network is the IP network for processes executed in tmux or direct targets
+	// Network is the IP network for processes executed in tmux, direct or ssh targets
 	Network string
 
+	// Allocation is the mode of allocating addresses to apps in tmux and direct targets: ips | ports, empty means the one environment has been created with
+	Allocation string
+
+	// PortsIP is the IP all the apps listen on in ports allocation mode
+	PortsIP string
+
+	// SSHHost is the host apps are deployed to by ssh target
+	SSHHost string
+
+	// DockerNetwork is the /24 IP network created for apps deployed to docker target, it is chosen automatically if empty
+	DockerNetwork string
+
+	// Registry is the registry container images are pulled from before falling back to the original one
+	Registry string
+
+	// Supervise means apps deployed to direct target are restarted whenever they exit
+	Supervise bool
+
+	// RestartPolicy tells how apps exited in tmux windows are restarted: manual | always
+	RestartPolicy string
+
+	// TMuxLayout is the layout of tmux session: windows | dashboard
+	TMuxLayout string
+
+	// WindowOrder lists apps whose tmux windows are placed first, in this order
+	WindowOrder []string
+
+	// DryRun means apps are not deployed for real, so spec is not stored and environment is not modified
+	DryRun bool
+
 	// TestingMode means we are in testing mode and deployment should not block execution
 	TestingMode bool
 
 	// TestFilters are regular expressions used to filter tests to run
 	TestFilters []string
 
+	// SpecFormat is the format used to print spec
+	SpecFormat string
+
+	// ExportFormat is the format used to export the set
+	ExportFormat string
+
+	// ExportNetwork is the /24 network IPs for exported apps are taken from
+	ExportNetwork string
+
+	// GraphFormat is the format used to print dependency graph
+	GraphFormat string
+
+	// Repair means doctor repairs apps whose state differs from the one recorded in spec
+	Repair bool
+
+	// StateSync means full node added to running environment restores state from snapshot
+	StateSync bool
+
 	// VerboseLogging turns on verbose logging
 	VerboseLogging bool
 }
 
-// Config produces final config
-func (cf *ConfigFactory) Config() infra.Config {
+// Config produces final config, cmd is the command being executed
+func (cf *ConfigFactory) Config(ctx context.Context, cmd *cobra.Command) (infra.Config, error) {
 	if err := os.MkdirAll(cf.HomeDir, 0o700); err != nil && !errors.Is(err, os.ErrExist) {
-		panic(err)
+		return infra.Config{}, err
+	}
+	homeRoot, err := filepath.EvalSymlinks(cf.HomeDir)
+	if err != nil {
+		return infra.Config{}, err
+	}
+	homeRoot, err = filepath.Abs(homeRoot)
+	if err != nil {
+		return infra.Config{}, err
 	}
-	homeDir := must.String(filepath.Abs(must.String(filepath.EvalSymlinks(cf.HomeDir)))) + "/" + cf.EnvName
+	homeDir := homeRoot + "/" + cf.EnvName
 	if err := os.Mkdir(homeDir, 0o700); err != nil && !errors.Is(err, os.ErrExist) {
-		panic(err)
+		return infra.Config{}, err
+	}
+
+	setName := cf.SetName
+	if apps.IsSetFile(setName) {
+		// Path has to be absolute because spec created for the environment stores it
+		setName, err = filepath.Abs(setName)
+		if err != nil {
+			return infra.Config{}, err
+		}
+	}
+
+	// Environment which exists already is always managed using target and set it has been created with
+	target, recordedSet, err := infra.RecordedTargetAndSet(homeDir)
+	if err != nil {
+		return infra.Config{}, err
+	}
+	if target != "" && (target != cf.Target || recordedSet != setName) {
+		// Different target or set requested explicitly can't be used, otherwise values recorded in spec replace defaults
+		if (cmd.Flags().Changed("target") && target != cf.Target) || (cmd.Flags().Changed("set") && recordedSet != setName) {
+			return infra.Config{}, fmt.Errorf("environment %s has been created using target %s and set %s, destroy it first to use different ones",
+				cf.EnvName, target, recordedSet)
+		}
+		logger.Get(ctx).Info("Environment exists already, target and set it has been created with are used",
+			zap.String("env", cf.EnvName), zap.String("target", target), zap.String("set", recordedSet))
+		cf.Target = target
+		cf.SetName = recordedSet
+		setName = recordedSet
+	}
+
+	network, err := infra.ParseNetwork(cf.Network)
+	if err != nil {
+		return infra.Config{}, err
+	}
+	var portsIP net.IP
+	if cf.PortsIP != "" {
+		portsIP = net.ParseIP(cf.PortsIP)
+		if portsIP == nil {
+			return infra.Config{}, fmt.Errorf("invalid ports IP %q", cf.PortsIP)
+		}
+	}
+	binDir, err := filepath.EvalSymlinks(cf.BinDir)
+	if err != nil {
+		return infra.Config{}, err
+	}
+	binDir, err = filepath.Abs(binDir)
+	if err != nil {
+		return infra.Config{}, err
 	}
 
 	config := infra.Config{
 		EnvName:        cf.EnvName,
-		SetName:        cf.SetName,
+		SetName:        setName,
 		Target:         cf.Target,
 		HomeDir:        homeDir,
 		AppDir:         homeDir + "/app",
 		LogDir:         homeDir + "/logs",
 		WrapperDir:     homeDir + "/bin",
-		BinDir:         must.String(filepath.Abs(must.String(filepath.EvalSymlinks(cf.BinDir)))),
-		Network:        net.ParseIP(cf.Network),
+		BinDir:         binDir,
+		Network:        network,
+		Allocation:     cf.Allocation,
+		PortsIP:        portsIP,
+		SSHHost:        cf.SSHHost,
+		DockerNetwork:  net.ParseIP(cf.DockerNetwork),
+		Registry:       cf.Registry,
+		Supervise:      cf.Supervise,
+		RestartPolicy:  cf.RestartPolicy,
+		TMuxLayout:     cf.TMuxLayout,
+		WindowOrder:    cf.WindowOrder,
+		DryRun:         cf.DryRun,
 		TestingMode:    cf.TestingMode,
 		VerboseLogging: cf.VerboseLogging,
 	}
 
+	if config.DryRun {
+		// Wrappers generated by apps must not overwrite the ones used by environment
+		config.WrapperDir = homeDir + "/dry-run/bin"
+	}
+
 	for _, v := range cf.TestFilters {
-		config.TestFilters = append(config.TestFilters, regexp.MustCompile(v))
+		filter, err := regexp.Compile(v)
+		if err != nil {
+			return infra.Config{}, fmt.Errorf("invalid test filter %q: %w", v, err)
+		}
+		config.TestFilters = append(config.TestFilters, filter)
 	}
 
-	createDirs(config)
+	if err := createDirs(config); err != nil {
+		return infra.Config{}, err
+	}
 
 	if !config.VerboseLogging {
 		logger.VerboseOff()
 	}
 
-	return config
+	return config, nil
 }
 
-func createDirs(config infra.Config) {
-	if err := os.MkdirAll(config.AppDir, 0o700); err != nil && !errors.Is(err, os.ErrExist) {
-		panic(err)
-	}
-	if err := os.MkdirAll(config.WrapperDir, 0o700); err != nil && !errors.Is(err, os.ErrExist) {
-		panic(err)
-	}
-	if err := os.MkdirAll(config.LogDir, 0o700); err != nil && !errors.Is(err, os.ErrExist) {
-		panic(err)
+func createDirs(config infra.Config) error {
+	for _, dir := range []string{config.AppDir, config.WrapperDir, config.LogDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
 	}
+	return nil
 }